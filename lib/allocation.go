@@ -0,0 +1,152 @@
+package yajirobe
+
+import (
+	"sort"
+)
+
+// FundHolding アセットクラスに含まれるファンド
+// 同じファンドを複数の口座や注文で保有している場合は合算したものになる
+type FundHolding struct {
+	Fund            // 合算したファンド
+	Weight  float64 // ファンドのうちこのアセットクラスに割り当てた比率
+	Sources []Fund  // 合算元のファンド
+}
+
+func newFundHolding(fu *fundUnited, weight float64) FundHolding {
+	h := FundHolding{
+		Fund:    copyFund(fu.Fund),
+		Weight:  weight,
+		Sources: make([]Fund, len(fu.sources)),
+	}
+
+	for i, s := range fu.sources {
+		h.Sources[i] = copyFund(s)
+	}
+
+	return h
+}
+
+// copyFund 資産構成も含めてファンドを複製する
+func copyFund(f *Fund) Fund {
+	c := *f
+	if f.Composition != nil {
+		c.Composition = Composition{}
+		for class, w := range f.Composition {
+			c.Composition[class] = w
+		}
+	}
+	return c
+}
+
+// Class アセットクラス
+func (d *AssetClassDetail) Class() AssetClass {
+	return d.class
+}
+
+// AcquisitionPrice 取得金額
+func (d *AssetClassDetail) AcquisitionPrice() float64 {
+	return d.aprice
+}
+
+// CurrentPrice 評価額
+func (d *AssetClassDetail) CurrentPrice() float64 {
+	return d.cprice
+}
+
+// TargetRatio 目標割合
+func (d *AssetClassDetail) TargetRatio() float64 {
+	return d.targetRatio
+}
+
+// CurrentRatio 実際の割合
+func (d *AssetClassDetail) CurrentRatio() float64 {
+	return d.currentRatio
+}
+
+// TargetPrice 目標金額
+func (d *AssetClassDetail) TargetPrice() float64 {
+	return d.targetPrice
+}
+
+// Diff 目標金額からの差分
+func (d *AssetClassDetail) Diff() float64 {
+	return d.diffPrice
+}
+
+// ProfitAndLossRatio 損益率
+func (d *AssetClassDetail) ProfitAndLossRatio() float64 {
+	return d.pl
+}
+
+// Funds アセットクラスに含まれるファンドを協会コード順に返す
+// 返した値を変更してもAssetClassDetailには影響しない
+func (d *AssetClassDetail) Funds() []FundHolding {
+	codes := make([]string, 0, len(d.funds))
+	for code := range d.funds {
+		codes = append(codes, string(code))
+	}
+	sort.Strings(codes)
+
+	holdings := make([]FundHolding, len(codes))
+	for i, code := range codes {
+		holdings[i] = newFundHolding(d.funds[FundCode(code)], d.weights[FundCode(code)])
+	}
+
+	return holdings
+}
+
+// AcquisitionPrice 取得金額の合計
+func (a *AssetAllocation) AcquisitionPrice() float64 {
+	return a.aprice
+}
+
+// CurrentPrice 評価額の合計
+func (a *AssetAllocation) CurrentPrice() float64 {
+	return a.cprice
+}
+
+// ProfitAndLoss 損益
+func (a *AssetAllocation) ProfitAndLoss() float64 {
+	return a.cprice - a.aprice
+}
+
+// ProfitAndLossRatio 損益率
+func (a *AssetAllocation) ProfitAndLossRatio() float64 {
+	return a.cprice/a.aprice - 1
+}
+
+// Target 目標アロケーション
+// 返した値を変更してもAssetAllocationには影響しない
+func (a *AssetAllocation) Target() *TargetNode {
+	if a.target == nil {
+		return nil
+	}
+	return a.target.clone()
+}
+
+// Classes 保有しているか目標に含まれるアセットクラスをAssetClasses順に返す
+func (a *AssetAllocation) Classes() []AssetClass {
+	classes := []AssetClass{}
+	for _, c := range AssetClasses() {
+		if _, e := a.details[c]; e {
+			classes = append(classes, c)
+		}
+	}
+	return classes
+}
+
+// Detail アセットクラスの明細
+func (a *AssetAllocation) Detail(class AssetClass) (*AssetClassDetail, bool) {
+	d, e := a.details[class]
+	return d, e
+}
+
+// Details アセットクラスの明細をAssetClasses順に返す
+func (a *AssetAllocation) Details() []*AssetClassDetail {
+	classes := a.Classes()
+	details := make([]*AssetClassDetail, len(classes))
+	for i, c := range classes {
+		details[i] = a.details[c]
+	}
+	return details
+}
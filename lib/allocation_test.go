@@ -0,0 +1,89 @@
+package yajirobe
+
+import (
+	"testing"
+)
+
+func TestAllocationAccessors(t *testing.T) {
+	a := testAllocation()
+
+	if a.CurrentPrice() != 1000 || a.AcquisitionPrice() != 500 || a.ProfitAndLoss() != 500 {
+		t.Errorf("unexpected totals: %v %v", a.CurrentPrice(), a.AcquisitionPrice())
+	}
+
+	classes := a.Classes()
+	if len(classes) != 3 || classes[0] != DomesticStocks || classes[1] != InternationalStocks || classes[2] != EmergingStocks {
+		t.Errorf("unexpected classes: %v", classes)
+	}
+
+	d, e := a.Detail(InternationalStocks)
+	if !e {
+		t.Fatal("expected InternationalStocks detail")
+	}
+
+	if d.TargetRatio() != 0.45 || d.CurrentRatio() != 0.6 || d.CurrentPrice() != 600 || d.Diff() != 150 {
+		t.Errorf("unexpected detail: %v %v %v %v", d.TargetRatio(), d.CurrentRatio(), d.CurrentPrice(), d.Diff())
+	}
+
+	if _, e := a.Detail(Balance); e {
+		t.Error("expected no Balance detail")
+	}
+
+	// 返した目標を変更しても影響しない
+	target := a.Target()
+	target.Children[0].Ratio = 0
+	target.Children = nil
+	if len(a.Target().Children) != 3 || a.Target().Children[0].Ratio == 0 {
+		t.Errorf("expected the target to be a copy but got %+v", a.Target())
+	}
+}
+
+func TestFundHoldingComposition(t *testing.T) {
+	f := newFund(Balance, 100)
+	f.Composition = Composition{DomesticStocks: 0.5, DomesticBonds: 0.5}
+
+	a := NewAssetAllocation([]*Stock{}, []*Fund{f}, AllocationTarget{DomesticStocks: 0.5, DomesticBonds: 0.5})
+
+	d, _ := a.Detail(DomesticStocks)
+	h := d.Funds()[0]
+	h.Composition[DomesticStocks] = 1
+	h.Sources[0].Composition[DomesticStocks] = 1
+
+	if w := d.Funds()[0].Composition[DomesticStocks]; w != 0.5 {
+		t.Errorf("expected the composition to be a copy but got %v", w)
+	}
+	if w := f.Composition[DomesticStocks]; w != 0.5 {
+		t.Errorf("expected the source composition to be unchanged but got %v", w)
+	}
+}
+
+func TestFundHoldingSources(t *testing.T) {
+	f1 := newFund(DomesticStocks, 100)
+	f1.Amount = 10000
+	f2 := newFund(DomesticStocks, 300)
+	f2.Amount = 20000
+
+	a := NewAssetAllocation([]*Stock{}, []*Fund{f1, f2}, AllocationTarget{DomesticStocks: 1})
+
+	d, _ := a.Detail(DomesticStocks)
+	funds := d.Funds()
+	if len(funds) != 1 {
+		t.Fatalf("expected merged fund but got %d funds", len(funds))
+	}
+
+	h := funds[0]
+	if h.Amount != 30000 || h.CurrentPrice != 400 || h.Weight != 1 {
+		t.Errorf("unexpected holding: %+v", h.Fund)
+	}
+
+	if len(h.Sources) != 2 || h.Sources[0].CurrentPrice != 100 || h.Sources[1].CurrentPrice != 300 {
+		t.Errorf("unexpected sources: %+v", h.Sources)
+	}
+
+	// 返した値を変更しても影響しない
+	h.Sources[0].CurrentPrice = 0
+	funds[0].CurrentPrice = 0
+	if d.Funds()[0].CurrentPrice != 400 || d.Funds()[0].Sources[0].CurrentPrice != 100 {
+		t.Error("expected holdings to be copies")
+	}
+}
@@ -2,6 +2,9 @@ package yajirobe
 
 import (
 	"encoding/json"
+	"io"
+	"strings"
+	"time"
 
 	"go.uber.org/zap"
 
@@ -12,28 +15,47 @@ import (
 
 // FundInfo ファンド情報
 type FundInfo struct {
-	Code  FundCode   `json:"code"`
-	Class AssetClass `json:"class"`
-	Name  string     `json:"name"`
+	Code        FundCode    `json:"code"`
+	Class       AssetClass  `json:"class"`
+	Name        string      `json:"name"`
+	Category    string      `json:"category,omitempty"` // SBIの商品分類
+	Composition Composition `json:"composition,omitempty"`
+	UpdatedAt   time.Time   `json:"updated_at"`       // 保存した時刻 有効期限はこの時刻から数える
+	Pinned      bool        `json:"pinned,omitempty"` // 手で設定したか読み込んだもの 期限切れにならず、取得し直さない
 }
 
 // Cache ファンド情報のキャッシュ
+// ファンド情報は有効期限を過ぎるとCanGetFundがfalseになる
+// SetFundとSetFundsはUpdatedAtがゼロのファンド情報に保存した時刻を入れる
 type Cache interface {
 	GetFund(code FundCode) (*FundInfo, error)
 	CanGetFund(code FundCode) bool
 	SetFund(info *FundInfo) error
+	SetFunds(infos []*FundInfo) error
+	FundCodes() ([]FundCode, error)
+	FundUpdatedAt(code FundCode) (time.Time, error)
+	IsFundExpired(code FundCode) bool
+	DeleteFund(code FundCode) error
+	DeleteFunds(codes []FundCode) error
 
 	GetString(key string) (string, error)
 	CanGetString(key string) bool
 	SetString(key, data string) error
+
+	// Records typの種類のレコードを読み書きする
+	Records(typ storedmap.RecordType) (*storedmap.Records, error)
 }
 
 type cache struct {
 	smap storedmap.StoredMap
+	ttl  time.Duration // ファンド情報の有効期限 0なら期限なし
+	now  func() time.Time
 }
 
+const fundKeyPrefix = "fund."
+
 func fundKey(code FundCode) string {
-	return "fund." + string(code)
+	return fundKeyPrefix + string(code)
 }
 
 func (c *cache) GetFund(code FundCode) (*FundInfo, error) {
@@ -52,21 +74,33 @@ func (c *cache) GetFund(code FundCode) (*FundInfo, error) {
 }
 
 func (c *cache) CanGetFund(code FundCode) bool {
-	return c.smap.CanGet(fundKey(code))
+	return c.smap.CanGet(fundKey(code)) && !c.IsFundExpired(code)
 }
 
-func (c *cache) SetFund(info *FundInfo) error {
+// marshalFund UpdatedAtがゼロなら今の時刻にしてJSONにする
+// 読み込んだファンド情報はUpdatedAtを保ったまま保存するので、有効期限はそのまま引き継ぐ
+func (c *cache) marshalFund(info *FundInfo) ([]byte, error) {
 	if info == nil {
-		return errors.New("info is nil")
+		return nil, errors.New("info is nil")
 	}
 
 	if info.Code == FundCode("") {
-		return errors.New("code must not be empty")
+		return nil, errors.New("code must not be empty")
+	}
+
+	fi := *info
+	if fi.UpdatedAt.IsZero() {
+		fi.UpdatedAt = c.now()
 	}
 
-	data, err := json.Marshal(info)
+	data, err := json.Marshal(&fi)
+	return data, errors.Wrap(err, "can't marshal fundinfo")
+}
+
+func (c *cache) SetFund(info *FundInfo) error {
+	data, err := c.marshalFund(info)
 	if err != nil {
-		return errors.Wrap(err, "can't marshal fundinfo")
+		return err
 	}
 
 	return errors.Wrap(
@@ -74,6 +108,82 @@ func (c *cache) SetFund(info *FundInfo) error {
 		"can't set to storedmap")
 }
 
+// SetFunds まとめて保存する ひとつでもJSONにできなければどれも保存しない
+// 書き込みに失敗した場合にどこまで保存するかはStoredMap.WriteBatchによる
+func (c *cache) SetFunds(infos []*FundInfo) error {
+	batch := &storedmap.Batch{}
+	for _, info := range infos {
+		data, err := c.marshalFund(info)
+		if err != nil {
+			return err
+		}
+		batch.Set(fundKey(info.Code), data)
+	}
+
+	return errors.Wrap(c.smap.WriteBatch(batch), "can't write batch to storedmap")
+}
+
+func (c *cache) FundCodes() ([]FundCode, error) {
+	keys, err := c.smap.Keys(fundKeyPrefix)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't list keys of storedmap")
+	}
+
+	codes := make([]FundCode, len(keys))
+	for i, k := range keys {
+		codes[i] = FundCode(strings.TrimPrefix(k, fundKeyPrefix))
+	}
+
+	return codes, nil
+}
+
+// FundUpdatedAt ファンド情報のUpdatedAt
+// UpdatedAtを持たない古いファンド情報ではゼロになる
+func (c *cache) FundUpdatedAt(code FundCode) (time.Time, error) {
+	fi, err := c.GetFund(code)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return fi.UpdatedAt, nil
+}
+
+// IsFundExpired UpdatedAtから有効期限を過ぎているか
+// Pinnedなファンド情報は期限切れにならない
+// UpdatedAtを持たない古いファンド情報は期限切れとして扱う
+func (c *cache) IsFundExpired(code FundCode) bool {
+	if c.ttl <= 0 {
+		return false
+	}
+
+	fi, err := c.GetFund(code)
+	if err != nil {
+		return true
+	}
+	if fi.Pinned {
+		return false
+	}
+	if fi.UpdatedAt.IsZero() {
+		return true
+	}
+
+	return c.now().Sub(fi.UpdatedAt) > c.ttl
+}
+
+func (c *cache) DeleteFund(code FundCode) error {
+	return errors.Wrap(c.smap.Delete(fundKey(code)), "can't delete from storedmap")
+}
+
+// DeleteFunds まとめて削除する キャッシュしていないファンドは無視する
+func (c *cache) DeleteFunds(codes []FundCode) error {
+	batch := &storedmap.Batch{}
+	for _, code := range codes {
+		batch.Delete(fundKey(code))
+	}
+
+	return errors.Wrap(c.smap.WriteBatch(batch), "can't write batch to storedmap")
+}
+
 func stringKey(key string) string {
 	return "string." + key
 }
@@ -97,21 +207,121 @@ func (c *cache) SetString(key, data string) error {
 		"can't set to storedmap")
 }
 
+func (c *cache) Records(typ storedmap.RecordType) (*storedmap.Records, error) {
+	return storedmap.NewRecords(c.smap, typ)
+}
+
+// fundDatabaseVersion ExportFundsで書き出すファイル形式のバージョン
+const fundDatabaseVersion = 1
+
+// FundDatabase キャッシュしているファンド情報をまとめて書き出したもの
+// 分類を手直ししたファンド情報を共有するのに使う
+type FundDatabase struct {
+	Version int         `json:"version"`
+	Funds   []*FundInfo `json:"funds"`
+}
+
+// ExportFunds キャッシュしているファンド情報をすべてJSONで書き出す
+func ExportFunds(c Cache, w io.Writer) error {
+	codes, err := c.FundCodes()
+	if err != nil {
+		return err
+	}
+
+	db := &FundDatabase{Version: fundDatabaseVersion, Funds: []*FundInfo{}}
+	for _, code := range codes {
+		fi, err := c.GetFund(code)
+		if err != nil {
+			return errors.Wrapf(err, "can't read cached fund %v", code)
+		}
+		db.Funds = append(db.Funds, fi)
+	}
+
+	return WriteJSON(w, db)
+}
+
+// ImportFunds ExportFundsで書き出したファンド情報をキャッシュに読み込む
+// 読み込んだファンド情報はPinnedにして、取得し直したときに上書きしないようにする
+// 読み込めないファンドがあればどのファンドも読み込まない
+// キャッシュへの書き込みに失敗した場合はSetFundsと同じく一部だけ読み込むことがある
+// overwriteでなければキャッシュ済みのファンドは有効期限を過ぎていても読み込まない
+// 読み込んだファンドの数を返す
+func ImportFunds(c Cache, r io.Reader, overwrite bool) (int, error) {
+	db := &FundDatabase{}
+	if err := json.NewDecoder(r).Decode(db); err != nil {
+		return 0, errors.Wrap(err, "can't decode fund database")
+	}
+
+	if db.Version != fundDatabaseVersion {
+		return 0, errors.Errorf("unsupported fund database version: %d", db.Version)
+	}
+
+	funds := []*FundInfo{}
+	for i, fi := range db.Funds {
+		if fi == nil || fi.Code == "" {
+			return 0, errors.Errorf("fund %d: code is required", i)
+		}
+		if !overwrite {
+			_, err := c.GetFund(fi.Code)
+			if err == nil {
+				continue
+			}
+			if !storedmap.IsNotExists(err) {
+				return 0, errors.Wrapf(err, "can't read cached fund %v", fi.Code)
+			}
+		}
+		fi.Pinned = true
+		funds = append(funds, fi)
+	}
+
+	if err := c.SetFunds(funds); err != nil {
+		return 0, err
+	}
+
+	return len(funds), nil
+}
+
 // NewMemoryCache creates a Cache
 func NewMemoryCache() Cache {
+	return NewCache(storedmap.NewMemoryMap(), 0)
+}
+
+// NewCache creates a Cache which stores entries in smap.
+// ttl is how long fund info stays valid. If ttl is 0, fund info never expires.
+func NewCache(smap storedmap.StoredMap, ttl time.Duration) Cache {
 	return &cache{
-		smap: storedmap.NewMemoryMap(),
+		smap: smap,
+		ttl:  ttl,
+		now:  time.Now,
 	}
 }
 
-// NewFileCache creates a Cache.
+// NewFileCache creates a Cache which stores each entry in a file.
+// Fund info never expires.
 // If logger is nil, NewFileCache uses NewNop as logger.
 func NewFileCache(logger *zap.Logger) (Cache, error) {
+	return NewFileCacheWithTTL(logger, 0)
+}
+
+// NewFileCacheWithTTL creates a Cache which stores each entry in a file.
+// If logger is nil, NewFileCacheWithTTL uses NewNop as logger.
+// ttl is how long fund info stays valid. If ttl is 0, fund info never expires.
+func NewFileCacheWithTTL(logger *zap.Logger, ttl time.Duration) (Cache, error) {
 	smap, err := storedmap.NewFileMap(logger)
 	if err != nil {
 		return nil, errors.Wrap(err, "can't create storedmap")
 	}
-	return &cache{
-		smap: smap,
-	}, nil
+	return NewCache(smap, ttl), nil
+}
+
+// NewBoltCache creates a Cache which stores all entries in a single database file.
+// The files of NewFileCache are migrated into the database when it is created.
+// If logger is nil, NewBoltCache uses NewNop as logger.
+// ttl is how long fund info stays valid. If ttl is 0, fund info never expires.
+func NewBoltCache(logger *zap.Logger, ttl time.Duration) (Cache, error) {
+	smap, err := storedmap.NewBoltMap(logger)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't create storedmap")
+	}
+	return NewCache(smap, ttl), nil
 }
@@ -1,7 +1,10 @@
 package yajirobe
 
 import (
+	"bytes"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/masaedw/yajirobe/lib/storedmap"
 )
@@ -57,3 +60,202 @@ func TestFileSetGet(t *testing.T) {
 		t.Fatalf("expected %v but got %v", info, cache)
 	}
 }
+
+func TestFundExpiry(t *testing.T) {
+	fc := NewMemoryCache()
+	c := fc.(*cache)
+	c.ttl = time.Hour
+
+	if err := fc.SetFund(&FundInfo{Code: "12345", Name: "TestFund"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !fc.CanGetFund("12345") || fc.IsFundExpired("12345") {
+		t.Fatal("expected fresh fund info")
+	}
+
+	c.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+
+	if fc.CanGetFund("12345") || !fc.IsFundExpired("12345") {
+		t.Fatal("expected expired fund info")
+	}
+
+	// 期限切れでもGetFundでは読める
+	if _, err := fc.GetFund("12345"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFundUpdatedAtAfterImport(t *testing.T) {
+	updatedAt := time.Now().Add(-2 * time.Hour).Round(0)
+	src := NewMemoryCache()
+	src.(*cache).now = func() time.Time { return updatedAt }
+	if err := src.SetFund(&FundInfo{Code: "12345", Name: "TestFund"}); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := ExportFunds(src, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := NewCache(storedmap.NewMemoryMap(), time.Hour)
+	if _, err := ImportFunds(dst, buf, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// 読み込んだ時刻ではなく、元のキャッシュに保存した時刻を保つ
+	if got, _ := dst.FundUpdatedAt("12345"); !got.Equal(updatedAt) {
+		t.Errorf("expected %v but got %v", updatedAt, got)
+	}
+
+	// 読み込んだファンド情報はPinnedなので期限切れにならない
+	if !dst.CanGetFund("12345") || dst.IsFundExpired("12345") {
+		t.Error("expected imported fund info not to expire")
+	}
+}
+
+func TestFundExpiryWithoutUpdatedAt(t *testing.T) {
+	smap := storedmap.NewMemoryMap()
+	smap.Set(fundKey("12345"), []byte(`{"code":"12345","name":"TestFund"}`))
+	fc := NewCache(smap, time.Hour)
+
+	if fc.CanGetFund("12345") || !fc.IsFundExpired("12345") {
+		t.Error("expected fund info without updated_at to be expired")
+	}
+}
+
+func TestFundCodesDelete(t *testing.T) {
+	fc := NewMemoryCache()
+	fc.SetString("key", "data")
+	for _, code := range []FundCode{"2", "1"} {
+		if err := fc.SetFund(&FundInfo{Code: code}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	codes, err := fc.FundCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 2 || codes[0] != "1" || codes[1] != "2" {
+		t.Fatalf("unexpected codes: %v", codes)
+	}
+
+	if err := fc.DeleteFund("1"); err != nil {
+		t.Fatal(err)
+	}
+	if fc.CanGetFund("1") {
+		t.Error("expected fund 1 to be deleted")
+	}
+}
+
+func TestExportImportFunds(t *testing.T) {
+	src := NewMemoryCache()
+	src.SetFund(&FundInfo{Code: "1", Name: "Fund1", Class: DomesticStocks, Category: "国内株式"})
+	src.SetFund(&FundInfo{Code: "2", Name: "Fund2", Composition: Composition{DomesticStocks: 0.5, DomesticBonds: 0.5}})
+
+	buf := &bytes.Buffer{}
+	if err := ExportFunds(src, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := NewMemoryCache()
+	dst.SetFund(&FundInfo{Code: "1", Name: "Edited", Class: InternationalStocks})
+
+	n, err := ImportFunds(dst, bytes.NewReader(buf.Bytes()), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("expected 1 imported fund but got %d", n)
+	}
+	if fi, _ := dst.GetFund("1"); fi.Name != "Edited" {
+		t.Errorf("expected existing fund to be kept but got %+v", fi)
+	}
+	if fi, _ := dst.GetFund("2"); fi.Composition[DomesticBonds] != 0.5 || !fi.Pinned {
+		t.Errorf("unexpected imported fund: %+v", fi)
+	}
+
+	n, err = ImportFunds(dst, bytes.NewReader(buf.Bytes()), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi, _ := dst.GetFund("1"); n != 2 || fi.Name != "Fund1" || fi.Class != DomesticStocks {
+		t.Errorf("expected fund to be overwritten but got %d %+v", n, fi)
+	}
+}
+
+func TestImportFundsKeepExpired(t *testing.T) {
+	src := NewMemoryCache()
+	src.SetFund(&FundInfo{Code: "1", Name: "Imported"})
+
+	buf := &bytes.Buffer{}
+	if err := ExportFunds(src, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := NewCache(storedmap.NewMemoryMap(), time.Hour)
+	dst.SetFund(&FundInfo{Code: "1", Name: "Cached", UpdatedAt: time.Now().Add(-2 * time.Hour)})
+	if !dst.IsFundExpired("1") {
+		t.Fatal("expected the cached fund to be expired")
+	}
+
+	// 期限切れでもキャッシュ済みのファンドは残す
+	n, err := ImportFunds(dst, buf, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi, _ := dst.GetFund("1"); n != 0 || fi.Name != "Cached" {
+		t.Errorf("expected the cached fund to be kept but got %d %+v", n, fi)
+	}
+}
+
+func TestImportFundsVersion(t *testing.T) {
+	_, err := ImportFunds(NewMemoryCache(), strings.NewReader(`{"version": 2, "funds": []}`), true)
+	if err == nil {
+		t.Fatal("expected an error for unsupported version")
+	}
+}
+
+func TestSetDeleteFunds(t *testing.T) {
+	fc := NewMemoryCache()
+
+	err := fc.SetFunds([]*FundInfo{{Code: "1"}, {Code: ""}})
+	if err == nil {
+		t.Fatal("expected an error for the empty code")
+	}
+	if fc.CanGetFund("1") {
+		t.Fatal("expected nothing to be saved")
+	}
+
+	if err := fc.SetFunds([]*FundInfo{{Code: "1"}, {Code: "2"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fc.DeleteFunds([]FundCode{"1", "3"}); err != nil {
+		t.Fatal(err)
+	}
+
+	codes, _ := fc.FundCodes()
+	if len(codes) != 1 || codes[0] != "2" {
+		t.Errorf("unexpected codes: %v", codes)
+	}
+}
+
+func TestCacheRecords(t *testing.T) {
+	fc := NewMemoryCache()
+
+	r, err := fc.Records(storedmap.RecordType{Name: "fund", Version: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Set("12345", "record"); err != nil {
+		t.Fatal(err)
+	}
+
+	// レコードはファンド情報とは別に保存する
+	if codes, _ := fc.FundCodes(); len(codes) != 0 {
+		t.Errorf("expected no funds but got %v", codes)
+	}
+}
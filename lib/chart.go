@@ -0,0 +1,137 @@
+package yajirobe
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/mattn/go-runewidth"
+	"github.com/pkg/errors"
+)
+
+const (
+	colorReset = "\x1b[0m"
+	colorOver  = "\x1b[31m" // 目標より多い
+	colorUnder = "\x1b[36m" // 目標より少ない
+
+	// chartTolerance 目標との差がこれ未満なら色をつけない
+	chartTolerance = 0.005
+)
+
+// barBlocks 1/8刻みの横棒
+var barBlocks = []rune(" ▏▎▍▌▋▊▉█")
+
+// chartWidth グラフの文字幅の数え方
+// 横棒と┃は東アジアの曖昧幅なので、ロケールによらず幅1として数える
+var chartWidth = &runewidth.Condition{EastAsianWidth: false}
+
+// ChartRenderer 目標と実際の割合を横棒グラフで書き出す
+// 棒の長さが実際の割合で、┃が目標の位置
+type ChartRenderer struct {
+	RenderOption
+	Width   int  // 端末の幅 0なら80
+	NoColor bool // 色をつけない
+}
+
+// bar 割合ratioの横棒 scaleは幅width全体に相当する割合
+func bar(ratio, scale float64, width int) []rune {
+	cells := make([]rune, width)
+	eighths := int(math.Round(ratio / scale * float64(width) * 8))
+
+	for i := range cells {
+		switch {
+		case eighths >= 8:
+			cells[i] = barBlocks[8]
+			eighths -= 8
+		case eighths > 0:
+			cells[i] = barBlocks[eighths]
+			eighths = 0
+		default:
+			cells[i] = ' '
+		}
+	}
+
+	return cells
+}
+
+// Render implements Renderer
+func (r *ChartRenderer) Render(w io.Writer, a *AssetAllocation, buy map[AssetClass]float64) error {
+	width := r.Width
+	if width <= 0 {
+		width = 80
+	}
+
+	p := newPrinter(r.Lang)
+	details := a.Details()
+
+	labelWidth := 0
+	scale := 0.0
+	for _, d := range details {
+		if lw := chartWidth.StringWidth(p.class(d.Class())); lw > labelWidth {
+			labelWidth = lw
+		}
+		scale = math.Max(scale, math.Max(finite(d.CurrentRatio()), d.TargetRatio()))
+	}
+	if scale == 0 {
+		scale = 1
+	}
+
+	// ラベル、空白、棒、" 100.0% / 100.0%"
+	const suffixWidth = 17
+	cols := width - labelWidth - suffixWidth - 1
+	if cols < 10 {
+		cols = 10
+	}
+
+	bw := bufio.NewWriter(w)
+
+	for _, d := range details {
+		current := finite(d.CurrentRatio())
+		cells := bar(current, scale, cols)
+
+		marker := int(d.TargetRatio() / scale * float64(cols))
+		if marker >= cols {
+			marker = cols - 1
+		}
+
+		color := ""
+		if !r.NoColor {
+			switch {
+			case current-d.TargetRatio() >= chartTolerance:
+				color = colorOver
+			case d.TargetRatio()-current >= chartTolerance:
+				color = colorUnder
+			}
+		}
+
+		label := p.class(d.Class())
+		bw.WriteString(label + strings.Repeat(" ", labelWidth-chartWidth.StringWidth(label)) + " ")
+
+		if color != "" {
+			bw.WriteString(color)
+		}
+		bw.WriteString(string(cells[:marker]))
+		if color != "" {
+			bw.WriteString(colorReset)
+		}
+		bw.WriteString("┃")
+		if color != "" {
+			bw.WriteString(color)
+		}
+		bw.WriteString(string(cells[marker+1:]))
+		if color != "" {
+			bw.WriteString(colorReset)
+		}
+
+		bw.WriteString(p.Sprintf(" %6.1f%% / %5.1f%%\n", current*100, d.TargetRatio()*100))
+	}
+
+	for _, c := range AssetClasses() {
+		if v, e := buy[c]; e {
+			p.Fprintf(bw, "%s\t%10s\n", p.class(c), money(p, v))
+		}
+	}
+
+	return errors.Wrap(bw.Flush(), "can't write chart")
+}
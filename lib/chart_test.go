@@ -0,0 +1,73 @@
+package yajirobe
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/language"
+)
+
+func TestBar(t *testing.T) {
+	for _, test := range []struct {
+		ratio    float64
+		expected string
+	}{
+		{0, "    "},
+		{1, "████"},
+		{0.5, "██  "},
+		{0.25 + 1.0/32, "█▏  "},
+	} {
+		if b := string(bar(test.ratio, 1, 4)); b != test.expected {
+			t.Errorf("bar(%v) expected %q but got %q", test.ratio, test.expected, b)
+		}
+	}
+}
+
+func TestChartRenderer(t *testing.T) {
+	a := testAllocation()
+
+	buf := &bytes.Buffer{}
+	r := &ChartRenderer{RenderOption: RenderOption{Lang: language.Japanese}, Width: 60, NoColor: true}
+	if err := r.Render(buf, &a, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 lines but got\n%s", buf.String())
+	}
+
+	for _, l := range lines {
+		if w := chartWidth.StringWidth(l); w != 60 {
+			t.Errorf("expected width 60 but got %d: %q", w, l)
+		}
+		if strings.Count(l, "┃") != 1 {
+			t.Errorf("expected a target marker: %q", l)
+		}
+		if strings.Contains(l, "\x1b[") {
+			t.Errorf("expected no color: %q", l)
+		}
+	}
+
+	if !strings.HasPrefix(lines[0], "国内株式") || !strings.HasSuffix(lines[0], "20.0% /  30.0%") {
+		t.Errorf("unexpected line: %q", lines[0])
+	}
+}
+
+func TestChartRendererColor(t *testing.T) {
+	a := testAllocation()
+
+	buf := &bytes.Buffer{}
+	if err := (&ChartRenderer{Width: 60}).Render(buf, &a, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(buf.String(), "\n")
+	if !strings.Contains(lines[0], colorUnder) {
+		t.Errorf("expected under-target color: %q", lines[0])
+	}
+	if !strings.Contains(lines[1], colorOver) {
+		t.Errorf("expected over-target color: %q", lines[1])
+	}
+}
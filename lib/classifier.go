@@ -0,0 +1,104 @@
+package yajirobe
+
+import (
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+// ClassificationRule 商品分類やファンド名からアセットクラスを決めるルール
+// CategoryとNameの両方を指定した場合は両方にマッチしたときだけ適用する
+type ClassificationRule struct {
+	Category string     `json:"category,omitempty"` // 商品分類にマッチする正規表現
+	Name     string     `json:"name,omitempty"`     // ファンド名にマッチする正規表現
+	Class    AssetClass `json:"class"`
+}
+
+// DefaultClassificationRules 標準の分類ルール
+var DefaultClassificationRules = []ClassificationRule{
+	{Category: "国内株式", Class: DomesticStocks},
+	{Category: "国内債券", Class: DomesticBonds},
+	{Category: "国内REIT", Class: DomesticREIT},
+	{Category: "海外株式", Class: InternationalStocks},
+	{Category: "海外債券", Class: InternationalBonds},
+	{Category: "海外REIT", Class: InternationalREIT},
+	{Category: "新興国株式", Class: EmergingStocks},
+	{Category: "新興国債券", Class: EmergingBonds},
+	{Category: "新興国REIT", Class: EmergingREIT},
+	{Category: "バランス", Class: Balance},
+	{Category: "コモディティ", Class: Comodity},
+	{Category: "ヘッジファンド", Class: HedgeFund},
+	{Category: "ブル・ベア", Class: BullBear},
+}
+
+type compiledRule struct {
+	category *regexp.Regexp
+	name     *regexp.Regexp
+	class    AssetClass
+}
+
+func (r *compiledRule) match(category, name string) bool {
+	if r.category != nil && !r.category.MatchString(category) {
+		return false
+	}
+	if r.name != nil && !r.name.MatchString(name) {
+		return false
+	}
+	return true
+}
+
+// Classifier ルールを先頭から順に評価してアセットクラスを決める
+type Classifier struct {
+	rules []compiledRule
+}
+
+// NewClassifier Classifierを作る
+func NewClassifier(rules []ClassificationRule) (*Classifier, error) {
+	c := &Classifier{}
+
+	for i, r := range rules {
+		if r.Category == "" && r.Name == "" {
+			return nil, errors.Errorf("rule %d: category or name is required", i)
+		}
+
+		cr := compiledRule{class: r.Class}
+
+		if r.Category != "" {
+			re, err := regexp.Compile(r.Category)
+			if err != nil {
+				return nil, errors.Wrapf(err, "rule %d: invalid category pattern", i)
+			}
+			cr.category = re
+		}
+
+		if r.Name != "" {
+			re, err := regexp.Compile(r.Name)
+			if err != nil {
+				return nil, errors.Wrapf(err, "rule %d: invalid name pattern", i)
+			}
+			cr.name = re
+		}
+
+		c.rules = append(c.rules, cr)
+	}
+
+	return c, nil
+}
+
+var defaultClassifier, _ = NewClassifier(DefaultClassificationRules)
+
+// DefaultClassifier 標準の分類ルールだけを使うClassifier
+func DefaultClassifier() *Classifier {
+	return defaultClassifier
+}
+
+// Classify 商品分類とファンド名からアセットクラスを決める
+// どのルールにもマッチしなければOtherを返す
+func (c *Classifier) Classify(category, name string) AssetClass {
+	for _, r := range c.rules {
+		if r.match(category, name) {
+			return r.class
+		}
+	}
+	return Other
+}
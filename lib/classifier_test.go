@@ -0,0 +1,75 @@
+package yajirobe
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestClassifyCorpus(t *testing.T) {
+	f, err := os.Open("testdata/sbi_categories.tsv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	c := DefaultClassifier()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		cols := strings.Split(text, "\t")
+		if len(cols) != 3 {
+			t.Fatalf("line %d: expected 3 columns but got %d", line, len(cols))
+		}
+
+		var expected AssetClass
+		if err := expected.UnmarshalText([]byte(cols[2])); err != nil {
+			t.Fatalf("line %d: %v", line, err)
+		}
+
+		if actual := c.Classify(cols[0], cols[1]); actual != expected {
+			t.Errorf("line %d: %s %s expected %v but got %v", line, cols[0], cols[1], expected, actual)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfigClassifier(t *testing.T) {
+	config := &Config{
+		Rules: []ClassificationRule{
+			{Category: "海外債券", Name: "為替ヘッジあり", Class: DomesticBonds},
+		},
+	}
+
+	c, err := config.Classifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if class := c.Classify("海外債券", "eMAXIS 先進国債券インデックス(為替ヘッジあり)"); class != DomesticBonds {
+		t.Errorf("expected %v but got %v", DomesticBonds, class)
+	}
+
+	if class := c.Classify("海外債券", "eMAXIS Slim 先進国債券インデックス"); class != InternationalBonds {
+		t.Errorf("expected %v but got %v", InternationalBonds, class)
+	}
+}
+
+func TestNewClassifierInvalidRule(t *testing.T) {
+	if _, err := NewClassifier([]ClassificationRule{{Class: DomesticStocks}}); err == nil {
+		t.Error("expected error for a rule without patterns")
+	}
+
+	if _, err := NewClassifier([]ClassificationRule{{Category: "(", Class: DomesticStocks}}); err == nil {
+		t.Error("expected error for an invalid pattern")
+	}
+}
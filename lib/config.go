@@ -0,0 +1,141 @@
+package yajirobe
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/masaedw/yajirobe/lib/storedmap"
+	"github.com/pkg/errors"
+)
+
+// Config 設定ファイル
+type Config struct {
+	AssetClasses []AssetClassDefinition   `json:"asset_classes,omitempty"`
+	Overrides    ClassOverrides           `json:"overrides,omitempty"`
+	Compositions map[FundCode]Composition `json:"compositions,omitempty"`
+	Rules        []ClassificationRule     `json:"rules,omitempty"`
+	Target       *TargetNode              `json:"target,omitempty"`
+}
+
+// TargetTree 設定ファイルの目標アロケーション
+// 設定されていなければdefaultTargetを使う
+func (c *Config) TargetTree(defaultTarget AllocationTarget) (*TargetNode, error) {
+	if c.Target == nil {
+		return NewTargetTree(defaultTarget), nil
+	}
+
+	if err := c.Target.Validate(); err != nil {
+		return nil, err
+	}
+
+	return c.Target, nil
+}
+
+// Classifier 分類ルールを 設定ファイルのルール、登録したアセットクラスのパターン、標準のルール の順に評価するClassifierを作る
+func (c *Config) Classifier() (*Classifier, error) {
+	rules := append([]ClassificationRule{}, c.Rules...)
+	rules = append(rules, registeredRules()...)
+	rules = append(rules, DefaultClassificationRules...)
+	return NewClassifier(rules)
+}
+
+// Apply 設定ファイルのアセットクラスと資産構成でfundsを上書きする
+// ファンドとして扱うETFも上書きできるように、stocksのETFをfundsに加えて返す
+func (c *Config) Apply(stocks []*Stock, funds []*Fund) []*Fund {
+	funds = withETFFunds(stocks, funds)
+
+	c.Overrides.Apply(funds)
+
+	for _, f := range funds {
+		if comp, e := c.Compositions[f.Code]; e {
+			f.Composition = comp
+		}
+	}
+
+	return funds
+}
+
+// ClassOverrides SBIの商品分類より優先するアセットクラス
+type ClassOverrides map[FundCode]AssetClass
+
+// Class codeの実際に使うアセットクラスを返す
+func (o ClassOverrides) Class(code FundCode, scraped AssetClass) AssetClass {
+	if c, e := o[code]; e {
+		return c
+	}
+	return scraped
+}
+
+// Apply fundsのアセットクラスを上書きする
+func (o ClassOverrides) Apply(funds []*Fund) {
+	for _, f := range funds {
+		f.AssetClass = o.Class(f.Code, f.AssetClass)
+	}
+}
+
+// DefaultConfigPath 設定ファイルの標準の場所
+func DefaultConfigPath() (string, error) {
+	dir, err := storedmap.ConfigDir()
+	if err != nil {
+		return "", errors.Wrap(err, "can't determine config directory")
+	}
+	return filepath.Join(dir, "config.json"), nil
+}
+
+// LoadConfig 設定ファイルを読み込む
+// ファイルがなければ空の設定を返す
+// 設定ファイルで定義したアセットクラスはRegisterAssetClassで登録する
+func LoadConfig(path string) (*Config, error) {
+	config := &Config{}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "can't read config file")
+	}
+
+	// 他の項目で使えるように、先にアセットクラスを登録しておく
+	classes := struct {
+		AssetClasses []AssetClassDefinition `json:"asset_classes"`
+	}{}
+	if err := json.Unmarshal(data, &classes); err != nil {
+		return nil, errors.Wrapf(err, "can't parse config file %s", path)
+	}
+	if err := RegisterAssetClasses(classes.AssetClasses); err != nil {
+		return nil, errors.Wrapf(err, "invalid asset class in %s", path)
+	}
+
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, errors.Wrapf(err, "can't parse config file %s", path)
+	}
+
+	for code, comp := range config.Compositions {
+		normalized, err := comp.Normalize()
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid composition of %s in %s", code, path)
+		}
+		config.Compositions[code] = normalized
+	}
+
+	return config, nil
+}
+
+// Save 設定ファイルに書き込む
+func (c *Config) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "can't marshal config")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.Wrap(err, "can't prepare config directory")
+	}
+
+	return errors.Wrap(
+		ioutil.WriteFile(path, append(data, '\n'), 0644),
+		"can't write config file")
+}
@@ -0,0 +1,157 @@
+package yajirobe
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfigNotExists(t *testing.T) {
+	c, err := LoadConfig(filepath.Join(os.TempDir(), "yajirobe-not-exists", "config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(c.Overrides) != 0 {
+		t.Fatalf("expected empty overrides but got %v", c.Overrides)
+	}
+}
+
+func TestConfigSaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "yajirobe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.json")
+
+	c := &Config{
+		Overrides: ClassOverrides{
+			FundCode("12345"): InternationalBonds,
+		},
+	}
+
+	if err := c.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if loaded.Overrides[FundCode("12345")] != InternationalBonds {
+		t.Fatalf("expected %v but got %v", InternationalBonds, loaded.Overrides)
+	}
+}
+
+func TestLoadConfigCompositions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "yajirobe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.json")
+	data := `{"compositions": {"1": {"DomesticStocks": 3, "DomesticBonds": 1, "Balance": 0}}}`
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Composition{DomesticStocks: 0.75, DomesticBonds: 0.25}
+	if comp := c.Compositions["1"]; len(comp) != 2 || comp[DomesticStocks] != 0.75 || comp[DomesticBonds] != 0.25 {
+		t.Errorf("expected %v but got %v", expected, comp)
+	}
+
+	data = `{"compositions": {"1": {"DomesticStocks": -1, "DomesticBonds": 2}}}`
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfig(path); err == nil {
+		t.Error("expected error for a negative weight")
+	}
+}
+
+func TestClassOverridesApply(t *testing.T) {
+	funds := []*Fund{
+		newFund(Balance, 100),
+		newFund(DomesticStocks, 100),
+	}
+
+	o := ClassOverrides{
+		funds[0].Code: DomesticBonds,
+	}
+	o.Apply(funds)
+
+	if funds[0].AssetClass != DomesticBonds {
+		t.Errorf("expected %v but got %v", DomesticBonds, funds[0].AssetClass)
+	}
+
+	if funds[1].AssetClass != DomesticStocks {
+		t.Errorf("expected %v but got %v", DomesticStocks, funds[1].AssetClass)
+	}
+}
+
+func TestConfigApplyETF(t *testing.T) {
+	stocks := []*Stock{
+		{Name: "外国株式ETF", Code: 1680, Amount: 10, CurrentPrice: 200},
+		{Name: "個別株", Code: 7203, Amount: 100, CurrentPrice: 300},
+	}
+	funds := []*Fund{newFund(DomesticStocks, 100)}
+
+	config := &Config{
+		Overrides:    ClassOverrides{"1680": Balance},
+		Compositions: map[FundCode]Composition{"1680": {InternationalStocks: 0.5, EmergingStocks: 0.5}},
+	}
+	funds = config.Apply(stocks, funds)
+
+	// ETFも設定ファイルで上書きする
+	if len(funds) != 2 || funds[1].Code != "1680" || funds[1].AssetClass != Balance || funds[1].Composition[EmergingStocks] != 0.5 {
+		t.Fatalf("expected the ETF to be overridden but got %+v", funds)
+	}
+
+	// ファンドに加えたETFを二重に数えない
+	a := NewAssetAllocationTree(stocks, funds, NewTargetTree(AllocationTarget{InternationalStocks: 0.5, EmergingStocks: 0.5}))
+	if a.CurrentPrice() != 300 {
+		t.Errorf("expected 300 but got %v", a.CurrentPrice())
+	}
+	if d, _ := a.Detail(InternationalStocks); d.CurrentPrice() != 100 {
+		t.Errorf("expected 100 but got %v", d.CurrentPrice())
+	}
+}
+
+func TestAssetClassUnmarshalJSON(t *testing.T) {
+	for _, test := range []struct {
+		json     string
+		expected AssetClass
+	}{
+		{`4`, InternationalStocks},
+		{`"InternationalStocks"`, InternationalStocks},
+		{`"海外株式"`, InternationalStocks},
+		{`"Commodity"`, Comodity},
+	} {
+		var c AssetClass
+		if err := json.Unmarshal([]byte(test.json), &c); err != nil {
+			t.Errorf("%s: %v", test.json, err)
+			continue
+		}
+		if c != test.expected {
+			t.Errorf("%s: expected %v but got %v", test.json, test.expected, c)
+		}
+	}
+
+	for _, invalid := range []string{`"NoSuchClass"`, `42`, `-1`} {
+		var c AssetClass
+		if err := json.Unmarshal([]byte(invalid), &c); err == nil {
+			t.Errorf("%s: expected error but got %v", invalid, c)
+		}
+	}
+}
@@ -0,0 +1,368 @@
+package yajirobe
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/mattn/go-runewidth"
+	"golang.org/x/text/language"
+)
+
+// targetStep 目標の編集で1回に増減する割合
+const targetStep = 0.005
+
+type dashboardMode int
+
+const (
+	modeAllocation dashboardMode = iota // アセットクラスごとの一覧
+	modeFunds                           // 選択したアセットクラスのファンド
+	modeAmount                          // 積立額の入力
+	modeTarget                          // 目標の編集
+)
+
+// Dashboard tuiコマンドの画面の状態
+// HandleKeyでキー入力を受け取り、Viewで画面を描く
+type Dashboard struct {
+	// Lang 出力に使う言語 language.Undなら以前と同じ表示
+	Lang language.Tag
+
+	stocks     []*Stock
+	funds      []*Fund
+	allocation AssetAllocation
+	save       func(*TargetNode) error
+
+	mode    dashboardMode
+	cursor  int
+	amount  string
+	buy     map[AssetClass]float64
+	message string
+
+	// 目標の編集中の状態
+	classes []AssetClass
+	edit    AllocationTarget
+	preview AssetAllocation
+}
+
+// NewDashboard Dashboardを作る
+// amountは積立額の初期値、saveは編集した目標を書き出す関数
+func NewDashboard(stocks []*Stock, funds []*Fund, tree *TargetNode, amount int64, save func(*TargetNode) error) *Dashboard {
+	d := &Dashboard{
+		stocks:     stocks,
+		funds:      funds,
+		allocation: NewAssetAllocationTree(stocks, funds, tree),
+		save:       save,
+	}
+
+	if amount > 0 {
+		d.amount = strconv.FormatInt(amount, 10)
+	}
+	d.rebalance()
+
+	return d
+}
+
+// contribution 入力中の積立額
+func (d *Dashboard) contribution() float64 {
+	v, _ := strconv.ParseFloat(d.amount, 64)
+	return v
+}
+
+// rebalance 積立額でリバランス購入を計算し直す
+func (d *Dashboard) rebalance() {
+	d.buy = nil
+	if v := d.contribution(); v > 0 {
+		d.buy = d.allocation.RebalancingBuy(v)
+	}
+}
+
+// editedTree 編集中の目標の木
+func (d *Dashboard) editedTree() *TargetNode {
+	return d.allocation.target.withAllocationTarget(d.edit)
+}
+
+func (d *Dashboard) startEditTarget() {
+	d.classes = d.allocation.Classes()
+	d.edit = d.allocation.target.AllocationTarget()
+	d.preview = d.allocation
+	d.cursor = 0
+	d.mode = modeTarget
+}
+
+// adjustTarget 選択中のアセットクラスの目標を増減してプレビューを計算し直す
+func (d *Dashboard) adjustTarget(delta float64) {
+	c := d.classes[d.cursor]
+	d.edit[c] = math.Max(0, math.Round((d.edit[c]+delta)/targetStep)*targetStep)
+	d.preview = NewAssetAllocationTree(d.stocks, d.funds, d.editedTree())
+}
+
+func (d *Dashboard) saveTarget() {
+	// 木に変換すると割合は正規化されるので、編集した割合のまま検査する
+	sum := 0.0
+	for _, v := range d.edit {
+		sum += v
+	}
+	if math.Abs(sum-1) > 1e-6 {
+		d.message = newPrinter(d.Lang).Sprintf("target ratios sum up to %.1f%%, expected 100%%", sum*100)
+		return
+	}
+
+	tree := d.editedTree()
+	if err := tree.Validate(); err != nil {
+		d.message = err.Error()
+		return
+	}
+
+	if d.save != nil {
+		if err := d.save(tree); err != nil {
+			d.message = err.Error()
+			return
+		}
+	}
+
+	d.allocation = NewAssetAllocationTree(d.stocks, d.funds, tree)
+	d.rebalance()
+	d.message = newPrinter(d.Lang).Sprintf("Saved the new target")
+	d.cursor = 0
+	d.mode = modeAllocation
+}
+
+// rows 選択できる行の数
+func (d *Dashboard) rows() int {
+	switch d.mode {
+	case modeTarget:
+		return len(d.classes)
+	case modeFunds:
+		return 0
+	default:
+		return len(d.allocation.Details())
+	}
+}
+
+func (d *Dashboard) moveCursor(delta int) {
+	n := d.rows()
+	if n == 0 {
+		return
+	}
+	d.cursor = (d.cursor + delta + n) % n
+}
+
+// HandleKey キー入力を処理する
+// 終了する場合はtrueを返す
+func (d *Dashboard) HandleKey(k Key) bool {
+	if k.Code == KeyCtrlC {
+		return true
+	}
+
+	d.message = ""
+
+	switch d.mode {
+	case modeAllocation:
+		switch {
+		case k.Code == KeyUp || k.Rune == 'k':
+			d.moveCursor(-1)
+		case k.Code == KeyDown || k.Rune == 'j':
+			d.moveCursor(1)
+		case k.Code == KeyEnter || k.Code == KeyRight:
+			if d.rows() > 0 {
+				d.mode = modeFunds
+			}
+		case k.Rune == 'a':
+			d.mode = modeAmount
+		case k.Rune == 't':
+			d.startEditTarget()
+		case k.Rune == 'q':
+			return true
+		}
+
+	case modeFunds:
+		switch {
+		case k.Code == KeyEscape || k.Code == KeyLeft || k.Code == KeyBackspace || k.Code == KeyEnter:
+			d.mode = modeAllocation
+		case k.Rune == 'q':
+			return true
+		}
+
+	case modeAmount:
+		switch {
+		case k.Rune >= '0' && k.Rune <= '9':
+			if d.amount != "" || k.Rune != '0' {
+				d.amount += string(k.Rune)
+			}
+		case k.Code == KeyBackspace:
+			if d.amount != "" {
+				d.amount = d.amount[:len(d.amount)-1]
+			}
+		case k.Code == KeyEnter || k.Code == KeyEscape:
+			d.mode = modeAllocation
+		}
+		d.rebalance()
+
+	case modeTarget:
+		switch {
+		case k.Code == KeyUp || k.Rune == 'k':
+			d.moveCursor(-1)
+		case k.Code == KeyDown || k.Rune == 'j':
+			d.moveCursor(1)
+		case k.Code == KeyRight || k.Rune == '+' || k.Rune == 'l':
+			d.adjustTarget(targetStep)
+		case k.Code == KeyLeft || k.Rune == '-' || k.Rune == 'h':
+			d.adjustTarget(-targetStep)
+		case k.Rune == 's':
+			d.saveTarget()
+		case k.Code == KeyEscape:
+			d.cursor = 0
+			d.mode = modeAllocation
+		}
+	}
+
+	return false
+}
+
+// writeColumns 列の幅をそろえて書く
+// 先頭列だけ左寄せで、selectedの行は反転表示する
+func writeColumns(b *strings.Builder, header []string, rows [][]string, selected int) {
+	widths := make([]int, len(header))
+	for _, row := range append([][]string{header}, rows...) {
+		for i, c := range row {
+			if w := runewidth.StringWidth(c); w > widths[i] {
+				widths[i] = w
+			}
+		}
+	}
+
+	line := func(cells []string) string {
+		s := make([]string, len(cells))
+		for i, c := range cells {
+			pad := strings.Repeat(" ", widths[i]-runewidth.StringWidth(c))
+			if i == 0 {
+				s[i] = c + pad
+			} else {
+				s[i] = pad + c
+			}
+		}
+		return strings.Join(s, "  ")
+	}
+
+	b.WriteString("\x1b[1m" + line(header) + "\x1b[0m\n")
+	for i, row := range rows {
+		if i == selected {
+			b.WriteString("\x1b[7m" + line(row) + "\x1b[0m\n")
+		} else {
+			b.WriteString(line(row) + "\n")
+		}
+	}
+}
+
+func buyCell(p *printer, buy map[AssetClass]float64, c AssetClass) string {
+	if v, e := buy[c]; e {
+		return money(p, v)
+	}
+	return ""
+}
+
+func (d *Dashboard) viewAllocation(b *strings.Builder, p *printer) {
+	rows := [][]string{}
+	for _, detail := range d.allocation.Details() {
+		row := detailRow(p, detail)
+		rows = append(rows, append(row[:5], buyCell(p, d.buy, detail.Class())))
+	}
+
+	writeColumns(b, p.all("Class", "Target", "Actual", "Current", "Diff", "Buy"), rows, d.cursor)
+}
+
+func (d *Dashboard) viewFunds(b *strings.Builder, p *printer) {
+	detail := d.allocation.Details()[d.cursor]
+	b.WriteString(p.class(detail.Class()) + "\n\n")
+
+	rows := [][]string{}
+	for _, h := range detail.Funds() {
+		name := h.Name
+		if h.Weight != 1 {
+			name += p.Sprintf(" (%.1f%%)", h.Weight*100)
+		}
+		rows = append(rows, fundRow(p, name, &h.Fund))
+	}
+
+	writeColumns(b, fundHeader(p), rows, -1)
+}
+
+func (d *Dashboard) viewTarget(b *strings.Builder, p *printer) {
+	buy := map[AssetClass]float64{}
+	if v := d.contribution(); v > 0 {
+		buy = d.preview.RebalancingBuy(v)
+	}
+
+	rows := [][]string{}
+	sum := 0.0
+	for _, c := range d.classes {
+		current := 0.0
+		if detail, e := d.allocation.Detail(c); e {
+			current = detail.TargetRatio()
+		}
+
+		actual, diff := "", ""
+		if detail, e := d.preview.Detail(c); e {
+			actual = p.Sprintf("%.1f%%", finite(detail.CurrentRatio())*100)
+			diff = p.Sprintf("%.0f", detail.Diff())
+		}
+
+		sum += d.edit[c]
+		rows = append(rows, []string{
+			p.class(c),                         // Class
+			p.Sprintf("%.1f%%", current*100),   // Target
+			p.Sprintf("%.1f%%", d.edit[c]*100), // New target
+			actual,                             // Actual
+			diff,                               // Diff
+			buyCell(p, buy, c),                 // Buy
+		})
+	}
+
+	writeColumns(b, p.all("Class", "Target", "New target", "Actual", "Diff", "Buy"), rows, d.cursor)
+	b.WriteString("\n" + p.Sprintf("Sum: %.1f%%", sum*100) + "\n")
+}
+
+// View 画面の内容
+func (d *Dashboard) View(width int) string {
+	p := newPrinter(d.Lang)
+	b := &strings.Builder{}
+
+	b.WriteString(p.Sprintf("Current: %s / P/L: %s",
+		money(p, d.allocation.CurrentPrice()),
+		p.Sprintf("%.1f%%", finite(d.allocation.ProfitAndLossRatio())*100)) + "\n")
+
+	amount := d.amount
+	if d.mode == modeAmount {
+		amount += "_"
+	}
+	b.WriteString(p.Sprintf("Contribution: %s", amount) + "\n\n")
+
+	switch d.mode {
+	case modeFunds:
+		d.viewFunds(b, p)
+	case modeTarget:
+		d.viewTarget(b, p)
+	default:
+		d.viewAllocation(b, p)
+	}
+
+	b.WriteString("\n")
+	if d.message != "" {
+		b.WriteString(d.message + "\n")
+	}
+
+	var help string
+	switch d.mode {
+	case modeAllocation:
+		help = p.Sprintf("↑↓: select  Enter: funds  a: contribution  t: edit target  q: quit")
+	case modeFunds:
+		help = p.Sprintf("Esc: back  q: quit")
+	case modeAmount:
+		help = p.Sprintf("0-9: input  Backspace: delete  Enter: done")
+	case modeTarget:
+		help = p.Sprintf("↑↓: select  ←→: -/+0.5%%  s: save  Esc: cancel")
+	}
+	b.WriteString(runewidth.Truncate(help, width, ""))
+
+	return b.String()
+}
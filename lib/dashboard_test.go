@@ -0,0 +1,135 @@
+package yajirobe
+
+import (
+	"bufio"
+	"math"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/language"
+)
+
+func TestReadKey(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("\x1b[A\x1b[D\r\x7fa国\x03"))
+
+	expected := []Key{
+		{Code: KeyUp},
+		{Code: KeyLeft},
+		{Code: KeyEnter},
+		{Code: KeyBackspace},
+		{Code: KeyRune, Rune: 'a'},
+		{Code: KeyRune, Rune: '国'},
+		{Code: KeyCtrlC},
+	}
+
+	for _, e := range expected {
+		k, err := readKey(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if k != e {
+			t.Errorf("expected %+v but got %+v", e, k)
+		}
+	}
+}
+
+func typeKeys(d *Dashboard, s string) {
+	for _, c := range s {
+		d.HandleKey(Key{Code: KeyRune, Rune: c})
+	}
+}
+
+func TestDashboardContribution(t *testing.T) {
+	a := testAllocation()
+	d := NewDashboard([]*Stock{}, testFunds(), a.Target(), 0, nil)
+
+	if d.buy != nil {
+		t.Fatalf("expected no buy without a contribution: %v", d.buy)
+	}
+
+	d.HandleKey(Key{Code: KeyRune, Rune: 'a'})
+	typeKeys(d, "1000")
+	d.HandleKey(Key{Code: KeyBackspace})
+
+	// 入力のたびにリバランス購入を計算し直す
+	if d.buy[DomesticStocks] != 63 || d.buy[EmergingStocks] != 37 {
+		t.Errorf("unexpected buy: %v", d.buy)
+	}
+
+	d.HandleKey(Key{Code: KeyEnter})
+	d.Lang = language.Japanese
+	if v := d.View(80); !strings.Contains(v, "積立額: 100\n") {
+		t.Errorf("unexpected view:\n%s", v)
+	}
+	d.Lang = language.English
+	if v := d.View(80); !strings.Contains(v, "Contribution: 100\n") {
+		t.Errorf("unexpected view:\n%s", v)
+	}
+}
+
+func TestDashboardFunds(t *testing.T) {
+	a := testAllocation()
+	d := NewDashboard([]*Stock{}, testFunds(), a.Target(), 0, nil)
+
+	d.HandleKey(Key{Code: KeyDown})
+	d.HandleKey(Key{Code: KeyEnter})
+
+	// 2行目の海外株式のファンドを表示する
+	v := d.View(80)
+	if !strings.HasPrefix(strings.Split(v, "\n")[3], InternationalStocks.String()) || !strings.Contains(v, "TestFund") {
+		t.Errorf("unexpected view:\n%s", v)
+	}
+
+	d.HandleKey(Key{Code: KeyEscape})
+	if d.mode != modeAllocation {
+		t.Error("expected to go back to the allocation")
+	}
+}
+
+func TestDashboardEditTarget(t *testing.T) {
+	a := testAllocation()
+
+	var saved *TargetNode
+	d := NewDashboard([]*Stock{}, testFunds(), a.Target(), 100, func(tree *TargetNode) error {
+		saved = tree
+		return nil
+	})
+
+	d.HandleKey(Key{Code: KeyRune, Rune: 't'})
+
+	// 国内株式を5%減らすと合計が100%にならないので保存できない
+	for i := 0; i < 10; i++ {
+		d.HandleKey(Key{Code: KeyLeft})
+	}
+	d.HandleKey(Key{Code: KeyRune, Rune: 's'})
+	if saved != nil || d.mode != modeTarget {
+		t.Fatal("expected not to save a target not summing up to 1")
+	}
+
+	// 海外株式を5%増やす
+	d.HandleKey(Key{Code: KeyDown})
+	for i := 0; i < 10; i++ {
+		d.HandleKey(Key{Code: KeyRight})
+	}
+
+	// 保存前にプレビューで差額が変わる
+	if detail, _ := d.preview.Detail(InternationalStocks); math.Abs(detail.Diff()-100) > 1e-6 {
+		t.Errorf("unexpected preview diff: %v", detail.Diff())
+	}
+	if detail, _ := d.allocation.Detail(InternationalStocks); detail.Diff() != 150 {
+		t.Errorf("allocation should not change before saving: %v", detail.Diff())
+	}
+
+	d.HandleKey(Key{Code: KeyRune, Rune: 's'})
+	if saved == nil {
+		t.Fatalf("expected to save: %s", d.message)
+	}
+
+	target := saved.AllocationTarget()
+	if math.Abs(target[DomesticStocks]-0.25) > 1e-9 || math.Abs(target[InternationalStocks]-0.5) > 1e-9 {
+		t.Errorf("unexpected saved target: %v", target)
+	}
+	if d.mode != modeAllocation || d.buy == nil {
+		t.Error("expected to return to the allocation with the new target")
+	}
+}
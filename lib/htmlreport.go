@@ -0,0 +1,237 @@
+package yajirobe
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+
+	"github.com/pkg/errors"
+	"golang.org/x/text/language"
+)
+
+// chartColors グラフの色 (アセットクラスの表示順に割り当てる)
+var chartColors = []string{
+	"#4e79a7", "#f28e2b", "#e15759", "#76b7b2", "#59a14f", "#edc948", "#b07aa1",
+	"#ff9da7", "#9c755f", "#bab0ac", "#86bcb6", "#d37295", "#fabfd2", "#8cd17d",
+}
+
+type pieSlice struct {
+	Label   string
+	Color   string
+	Percent float64
+	Path    string
+	Circle  bool // 1つのアセットクラスで100%を占める場合は円を描く
+}
+
+type pieChart struct {
+	Title  string
+	Slices []pieSlice
+}
+
+type diffBar struct {
+	Label string
+	Diff  string
+	X     float64
+	Y     float64
+	Width float64
+	TextY float64
+	Over  bool
+}
+
+type htmlReport struct {
+	Lang       string
+	Labels     map[string]string
+	Summary    string
+	Pies       []pieChart
+	Bars       []diffBar
+	BarsHeight float64
+	Allocation [][]string
+	Header     []string
+	Funds      [][]string
+	FundHeader []string
+}
+
+const (
+	pieRadius = 100.0
+	barWidth  = 560.0
+	barHeight = 20.0
+	barGap    = 8.0
+)
+
+// newPieChart ratiosの割合でパイチャートを作る
+func newPieChart(p *printer, title string, classes []AssetClass, ratios []float64) pieChart {
+	chart := pieChart{Title: title}
+
+	angle := -math.Pi / 2
+	for i, r := range ratios {
+		if r <= 0 {
+			continue
+		}
+
+		s := pieSlice{
+			Label:   p.class(classes[i]),
+			Color:   chartColors[i%len(chartColors)],
+			Percent: r * 100,
+		}
+
+		if r >= 1 {
+			s.Circle = true
+		} else {
+			next := angle + r*2*math.Pi
+			large := 0
+			if r > 0.5 {
+				large = 1
+			}
+			s.Path = fmt.Sprintf("M0,0 L%.3f,%.3f A%.0f,%.0f 0 %d 1 %.3f,%.3f Z",
+				pieRadius*math.Cos(angle), pieRadius*math.Sin(angle),
+				pieRadius, pieRadius, large,
+				pieRadius*math.Cos(next), pieRadius*math.Sin(next))
+			angle = next
+		}
+
+		chart.Slices = append(chart.Slices, s)
+	}
+
+	return chart
+}
+
+// newDiffBars 目標金額からの差分の棒グラフ
+// 中央を0として、目標より多ければ右、少なければ左に伸ばす
+func newDiffBars(p *printer, a *AssetAllocation) ([]diffBar, float64) {
+	details := a.Details()
+
+	max := 0.0
+	for _, d := range details {
+		max = math.Max(max, math.Abs(d.Diff()))
+	}
+
+	bars := []diffBar{}
+	center := barWidth / 2
+	for i, d := range details {
+		w := 0.0
+		if max > 0 {
+			w = math.Abs(d.Diff()) / max * (center - 10)
+		}
+
+		y := float64(i) * (barHeight + barGap)
+		b := diffBar{
+			Label: p.class(d.Class()),
+			Diff:  p.Sprintf("%.0f", d.Diff()),
+			Y:     y,
+			Width: w,
+			TextY: y + barHeight*0.75,
+			Over:  d.Diff() > 0,
+		}
+		if b.Over {
+			b.X = center
+		} else {
+			b.X = center - w
+		}
+		bars = append(bars, b)
+	}
+
+	return bars, float64(len(details)) * (barHeight + barGap)
+}
+
+// WriteHTMLReport グラフと明細を含む1ファイルで完結するHTMLをlangで書き出す
+func WriteHTMLReport(w io.Writer, a *AssetAllocation, lang language.Tag) error {
+	p := newPrinter(lang)
+
+	classes := a.Classes()
+	current := make([]float64, len(classes))
+	target := make([]float64, len(classes))
+	for i, c := range classes {
+		d, _ := a.Detail(c)
+		current[i] = finite(d.CurrentRatio())
+		target[i] = d.TargetRatio()
+	}
+
+	bars, height := newDiffBars(p, a)
+
+	data := htmlReport{
+		Lang: HTMLLang(lang),
+		Labels: map[string]string{
+			"Title":      p.Sprintf("Asset allocation"),
+			"Diff":       p.Sprintf("Diff"),
+			"Allocation": p.Sprintf("Allocation"),
+			"Funds":      p.Sprintf("Funds"),
+		},
+		Summary: p.Sprintf("Current: %s / P/L: %s",
+			money(p, a.CurrentPrice()),
+			p.Sprintf("%.1f%%", finite(a.ProfitAndLossRatio())*100)),
+		Pies: []pieChart{
+			newPieChart(p, p.Sprintf("Actual"), classes, current),
+			newPieChart(p, p.Sprintf("Target"), classes, target),
+		},
+		Bars:       bars,
+		BarsHeight: height,
+		Header:     allocationHeader(p),
+		Allocation: a.allocationRows(p),
+		FundHeader: fundHeader(p),
+		Funds:      a.fundRows(p),
+	}
+
+	return errors.Wrap(htmlReportTemplate.Execute(w, data), "can't write html report")
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="{{.Lang}}">
+<head>
+<meta charset="utf-8">
+<title>yajirobe - {{.Labels.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #333; }
+h1 { font-size: 1.5em; }
+h2 { font-size: 1.2em; margin-top: 2em; }
+.pies { display: flex; flex-wrap: wrap; gap: 3em; }
+.legend { list-style: none; padding: 0; }
+.legend li { margin: 0.2em 0; }
+.swatch { display: inline-block; width: 0.9em; height: 0.9em; margin-right: 0.4em; vertical-align: middle; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 0.2em 0.6em; }
+td:not(:first-child) { text-align: right; }
+td:first-child { white-space: pre; }
+.over { fill: #e15759; }
+.under { fill: #4e79a7; }
+</style>
+</head>
+<body>
+<h1>{{.Labels.Title}}</h1>
+<p>{{.Summary}}</p>
+
+<div class="pies">
+{{range .Pies}}<div>
+<h2>{{.Title}}</h2>
+<svg width="220" height="220" viewBox="-110 -110 220 220" xmlns="http://www.w3.org/2000/svg">
+{{range .Slices}}{{if .Circle}}<circle r="100" fill="{{.Color}}"><title>{{.Label}} {{printf "%.1f" .Percent}}%</title></circle>
+{{else}}<path d="{{.Path}}" fill="{{.Color}}" stroke="#fff"><title>{{.Label}} {{printf "%.1f" .Percent}}%</title></path>
+{{end}}{{end}}</svg>
+<ul class="legend">
+{{range .Slices}}<li><span class="swatch" style="background: {{.Color}}"></span>{{.Label}} {{printf "%.1f" .Percent}}%</li>
+{{end}}</ul>
+</div>
+{{end}}</div>
+
+<h2>{{.Labels.Diff}}</h2>
+<svg width="800" height="{{.BarsHeight}}" xmlns="http://www.w3.org/2000/svg">
+<line x1="400" y1="0" x2="400" y2="{{.BarsHeight}}" stroke="#999"/>
+{{range .Bars}}<text x="0" y="{{.TextY}}" font-size="12">{{.Label}}</text>
+<rect x="{{.X}}" y="{{.Y}}" width="{{.Width}}" height="20" transform="translate(120,0)" class="{{if .Over}}over{{else}}under{{end}}"/>
+<text x="790" y="{{.TextY}}" font-size="12" text-anchor="end">{{.Diff}}</text>
+{{end}}</svg>
+
+<h2>{{.Labels.Allocation}}</h2>
+<table>
+<tr>{{range .Header}}<th>{{.}}</th>{{end}}</tr>
+{{range .Allocation}}<tr>{{range .}}<td>{{.}}</td>{{end}}</tr>
+{{end}}</table>
+
+<h2>{{.Labels.Funds}}</h2>
+<table>
+<tr>{{range .FundHeader}}<th>{{.}}</th>{{end}}</tr>
+{{range .Funds}}<tr>{{range .}}<td>{{.}}</td>{{end}}</tr>
+{{end}}</table>
+</body>
+</html>
+`))
@@ -0,0 +1,46 @@
+package yajirobe
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/language"
+)
+
+func TestWriteHTMLReport(t *testing.T) {
+	a := testAllocation()
+
+	buf := &bytes.Buffer{}
+	if err := WriteHTMLReport(buf, &a, language.Japanese); err != nil {
+		t.Fatal(err)
+	}
+
+	html := buf.String()
+
+	// 現在と目標の2つのパイチャートに3つずつ扇形がある
+	if n := strings.Count(html, "<path d=\"M0,0"); n != 6 {
+		t.Errorf("expected 6 pie slices but got %d", n)
+	}
+
+	for _, c := range []string{
+		`style="background: #4e79a7"`,
+		"<title>国内株式 20.0%</title>",
+		"<title>国内株式 30.0%</title>",
+		`class="over"`,
+		`class="under"`,
+		"<td>  TestFund</td>",
+	} {
+		if !strings.Contains(html, c) {
+			t.Errorf("expected to contain %q", c)
+		}
+	}
+}
+
+func TestPieChartFullCircle(t *testing.T) {
+	chart := newPieChart(newPrinter(language.Und), "Target", []AssetClass{DomesticStocks, DomesticBonds}, []float64{1, 0})
+
+	if len(chart.Slices) != 1 || !chart.Slices[0].Circle {
+		t.Errorf("expected a full circle but got %+v", chart.Slices)
+	}
+}
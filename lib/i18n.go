@@ -0,0 +1,209 @@
+package yajirobe
+
+import (
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"golang.org/x/text/message/catalog"
+)
+
+// Languages 対応している言語
+// language.Undは言語を指定しないことを表し、以前と同じく英語の見出しと登録したアセットクラスの表示名で出力する
+var Languages = []language.Tag{language.English, language.Japanese}
+
+var (
+	languageMatcher = language.NewMatcher(Languages)
+	messages        = newCatalog()
+)
+
+// japaneseMessages 英語のメッセージの日本語訳
+var japaneseMessages = map[string]string{
+	// 表の見出し
+	"Class":            "アセットクラス",
+	"Target":           "目標",
+	"Actual":           "実際",
+	"Current":          "評価額",
+	"Diff":             "差額",
+	"P/L":              "損益",
+	"P/L%%":            "損益率",
+	"Class / Fund":     "アセットクラス / ファンド",
+	"Amount":           "保有数量",
+	"Acquisition Unit": "取得単価",
+	"Current Unit":     "基準価額",
+	"Buy":              "購入額",
+	"Code":             "コード",
+	"Name":             "名前",
+	"Scraped":          "商品分類",
+	"Effective":        "適用",
+	"Total":            "全体",
+	"Category":         "商品分類",
+	"Updated":          "更新日時",
+
+	// 明細
+	"%s (not in allocation)": "%s (配分対象外)",
+
+	// HTMLレポート
+	"Asset allocation":      "アセットアロケーション",
+	"Allocation":            "配分",
+	"Funds":                 "ファンド",
+	"Current: %s / P/L: %s": "評価額: %s / 損益: %s",
+
+	// tui
+	"New target":           "新しい目標",
+	"Contribution: %s":     "積立額: %s",
+	"Sum: %.1f%%":          "合計: %.1f%%",
+	"Saved the new target": "新しい目標を保存しました",
+	"target ratios sum up to %.1f%%, expected 100%%":                     "目標の合計が%.1f%%です (100%%である必要があります)",
+	"↑↓: select  Enter: funds  a: contribution  t: edit target  q: quit": "↑↓: 選択  Enter: ファンド  a: 積立額  t: 目標を編集  q: 終了",
+	"Esc: back  q: quit":                             "Esc: 戻る  q: 終了",
+	"0-9: input  Backspace: delete  Enter: done":     "0-9: 入力  Backspace: 削除  Enter: 完了",
+	"↑↓: select  ←→: -/+0.5%%  s: save  Esc: cancel": "↑↓: 選択  ←→: -/+0.5%%  s: 保存  Esc: 取消",
+
+	// serve
+	"Rebalance":     "リバランス",
+	"Report":        "レポート",
+	"Refresh":       "再取得",
+	"Calculate":     "計算",
+	"Scanned at %s": "取得日時: %s",
+
+	// 金額
+	"¥%.0f": "%.0f円",
+}
+
+// englishMessages 日本語のメッセージの英訳
+var englishMessages = map[string]string{
+	// アセットクラス
+	"国内株式":    "Domestic Stocks",
+	"海外株式":    "International Stocks",
+	"新興国株式":   "Emerging Stocks",
+	"国内債券":    "Domestic Bonds",
+	"海外債券":    "International Bonds",
+	"新興国債券":   "Emerging Bonds",
+	"国内REIT":  "Domestic REIT",
+	"海外REIT":  "International REIT",
+	"新興国REIT": "Emerging REIT",
+	"バランス":    "Balance",
+	"コモディティ":  "Commodity",
+	"ヘッジファンド": "Hedge Fund",
+	"ブルベア":    "Bull/Bear",
+	"その他":     "Other",
+}
+
+func newCatalog() catalog.Catalog {
+	b := catalog.NewBuilder()
+
+	for key, msg := range japaneseMessages {
+		b.SetString(language.Japanese, key, msg)
+	}
+	for key, msg := range englishMessages {
+		b.SetString(language.English, key, msg)
+	}
+
+	return b
+}
+
+// ParseLanguage "ja"や"en_US.UTF-8"のような言語の指定から対応言語を得る
+func ParseLanguage(s string) (language.Tag, error) {
+	// ロケール名のエンコーディングと修飾子を取り除く
+	if i := strings.IndexAny(s, ".@"); i >= 0 {
+		s = s[:i]
+	}
+
+	tag, err := language.Parse(strings.Replace(s, "_", "-", -1))
+	if err != nil {
+		return language.Und, errors.Wrapf(err, "unsupported language: %s", s)
+	}
+
+	_, i, confidence := languageMatcher.Match(tag)
+	if confidence == language.No {
+		return language.Und, errors.Errorf("unsupported language: %s", s)
+	}
+
+	return Languages[i], nil
+}
+
+// DetectLanguage 環境変数LC_ALL、LC_MESSAGES、LANGから言語を得る
+// どれも指定されていないか、CやPOSIXならfalseを返す
+func DetectLanguage() (language.Tag, bool) {
+	for _, name := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		v := os.Getenv(name)
+		if v == "" {
+			continue
+		}
+		if v == "C" || v == "POSIX" || strings.HasPrefix(v, "C.") {
+			return language.Und, false
+		}
+
+		tag, err := ParseLanguage(v)
+		return tag, err == nil
+	}
+
+	return language.Und, false
+}
+
+// printer 出力に使う言語でメッセージや数値を書く
+type printer struct {
+	*message.Printer
+	lang language.Tag // 指定された言語 指定されていなければlanguage.Und
+}
+
+// newPrinter langで書くprinterを作る
+// 対応していない言語なら最も近い対応言語を使う
+func newPrinter(lang language.Tag) *printer {
+	return &printer{message.NewPrinter(resolveLanguage(lang), message.Catalog(messages)), lang}
+}
+
+// resolveLanguage 見出しや数値の書式に使う対応言語 指定されていなければ英語
+func resolveLanguage(lang language.Tag) language.Tag {
+	if lang == language.Und {
+		return language.English
+	}
+	_, i, _ := languageMatcher.Match(lang)
+	return Languages[i]
+}
+
+// Translate メッセージをlangに翻訳する
+// keyはfmt.Sprintfの書式で、対応する訳がなければkeyをそのまま使う
+func Translate(lang language.Tag, key string, args ...interface{}) string {
+	return newPrinter(lang).Sprintf(key, args...)
+}
+
+// HTMLLang HTMLのlang属性に書く言語
+func HTMLLang(lang language.Tag) string {
+	return resolveLanguage(lang).String()
+}
+
+// all 翻訳したメッセージの一覧
+func (p *printer) all(keys ...string) []string {
+	s := make([]string, len(keys))
+	for i, k := range keys {
+		s[i] = p.Sprintf(k)
+	}
+	return s
+}
+
+// class アセットクラスの表示名
+// 言語を指定していないか、利用者が表示名を定義したアセットクラスは翻訳しない
+func (p *printer) class(c AssetClass) string {
+	def := c.Definition()
+	if b, e := builtinAssetClasses[c]; p.lang != language.Und && e && b.Name == def.Name {
+		return p.Sprintf(def.Name)
+	}
+	return def.Name
+}
+
+// label 目標のノードの表示名
+func (p *printer) label(n *TargetNode) string {
+	if n.Name == "" && n.IsLeaf() {
+		return p.class(n.Class)
+	}
+	return n.Name
+}
+
+// money 金額を出力に使う言語の書式で書く
+func money(p *printer, v float64) string {
+	return p.Sprintf("¥%.0f", v)
+}
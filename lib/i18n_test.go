@@ -0,0 +1,108 @@
+package yajirobe
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/language"
+)
+
+func TestParseLanguage(t *testing.T) {
+	for _, test := range []struct {
+		input    string
+		expected language.Tag
+	}{
+		{"ja", language.Japanese},
+		{"en", language.English},
+		{"ja_JP.UTF-8", language.Japanese},
+		{"en_US.UTF-8", language.English},
+		{"en-GB", language.English},
+	} {
+		tag, err := ParseLanguage(test.input)
+		if err != nil {
+			t.Errorf("%s: %v", test.input, err)
+			continue
+		}
+		if tag != test.expected {
+			t.Errorf("%s: expected %v but got %v", test.input, test.expected, tag)
+		}
+	}
+
+	if _, err := ParseLanguage("not a language"); err == nil {
+		t.Error("expected error for an invalid language")
+	}
+}
+
+func TestDetectLanguage(t *testing.T) {
+	t.Setenv("LC_ALL", "")
+	t.Setenv("LC_MESSAGES", "")
+
+	t.Setenv("LANG", "en_US.UTF-8")
+	if tag, ok := DetectLanguage(); !ok || tag != language.English {
+		t.Errorf("expected English but got %v %v", tag, ok)
+	}
+
+	t.Setenv("LC_ALL", "ja_JP.UTF-8")
+	if tag, ok := DetectLanguage(); !ok || tag != language.Japanese {
+		t.Errorf("LC_ALL should take precedence over LANG: %v %v", tag, ok)
+	}
+
+	t.Setenv("LC_ALL", "C")
+	if _, ok := DetectLanguage(); ok {
+		t.Error("expected C locale to be ignored")
+	}
+}
+
+func TestEnglishOutput(t *testing.T) {
+	p := newPrinter(language.English)
+	if s := p.class(DomesticStocks); s != "Domestic Stocks" {
+		t.Errorf("unexpected class name: %s", s)
+	}
+
+	// 言語を指定しなければ以前と同じ日本語のアセットクラス名
+	if s := newPrinter(language.Und).class(DomesticStocks); s != "国内株式" {
+		t.Errorf("unexpected class name: %s", s)
+	}
+
+	for _, s := range []string{"Domestic Stocks", "国内株式", "DomesticStocks"} {
+		if c, err := ParseAssetClassName(s); err != nil || c != DomesticStocks {
+			t.Errorf("%s: expected DomesticStocks but got %v %v", s, c, err)
+		}
+	}
+
+	if _, err := ParseAssetClassName("Unknown"); err == nil || err.Error() != "unknown asset class: Unknown" {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	a := testAllocation()
+	buf := &bytes.Buffer{}
+	r, _ := NewRenderer("tsv", RenderOption{Lang: language.English})
+	if err := r.Render(buf, &a, a.RebalancingBuy(1000)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []string{
+		"Class\tTarget\tActual\tCurrent\tDiff\tP/L\n",
+		"Total\t\t\t1,000.00\t\t100.0%\n",
+		"Domestic Stocks\t30.0%\t20.0%\t200.00\t-100\t100.0%\n",
+		"Domestic Stocks\t¥",
+	} {
+		if !strings.Contains(buf.String(), c) {
+			t.Errorf("expected to contain %q but got\n%s", c, buf.String())
+		}
+	}
+}
+
+func TestCustomClassNameIsNotTranslated(t *testing.T) {
+	defer resetRegistry()
+
+	class, err := RegisterAssetClass(AssetClassDefinition{Key: "Platinum", Name: "プラチナ", Order: 125})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s := newPrinter(language.English).class(class); s != "プラチナ" {
+		t.Errorf("expected the user defined name but got %s", s)
+	}
+}
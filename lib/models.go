@@ -1,8 +1,14 @@
 package yajirobe
 
 import (
+	"encoding/json"
 	"fmt"
-	"regexp"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/text/language"
 )
 
 // Stock 銘柄
@@ -31,14 +37,15 @@ type FundCode string
 
 // Fund 投資信託
 type Fund struct {
-	Name                 string     // 名称
-	Code                 FundCode   // 協会コード
-	Amount               int        // 保有口数
-	AssetClass           AssetClass // アセットクラス
-	AcquisitionUnitPrice float64    // 取得単価
-	CurrentUnitPrice     float64    // 基準価額
-	AcquisitionPrice     float64    // 取得金額
-	CurrentPrice         float64    // 評価額
+	Name                 string      // 名称
+	Code                 FundCode    // 協会コード
+	Amount               int         // 保有口数
+	AssetClass           AssetClass  // アセットクラス
+	Composition          Composition // 資産構成 (バランスファンドの内訳)
+	AcquisitionUnitPrice float64     // 取得単価
+	CurrentUnitPrice     float64     // 基準価額
+	AcquisitionPrice     float64     // 取得金額
+	CurrentPrice         float64     // 評価額
 }
 
 // ProfitAndLoss 損益
@@ -85,104 +92,122 @@ const (
 	BullBear
 )
 
-// AssetClasses アセットクラス一覧
-var AssetClasses = []AssetClass{
-	DomesticStocks,
-	InternationalStocks,
-	EmergingStocks,
-	DomesticBonds,
-	InternationalBonds,
-	EmergingBonds,
-	DomesticREIT,
-	InternationalREIT,
-	EmergingREIT,
-	Balance,
-	Comodity,
-	HedgeFund,
-	BullBear,
-	Other,
-}
-
-var (
-	domesticStocksPattern      = regexp.MustCompile("国内株式")
-	domesticBondsPattern       = regexp.MustCompile("国内債券")
-	domesticREITPattern        = regexp.MustCompile("国内REIT")
-	internationalStocksPattern = regexp.MustCompile("海外株式")
-	internationalBondsPattern  = regexp.MustCompile("海外債券")
-	internationalREITPattern   = regexp.MustCompile("海外REIT")
-	emergingStocksPattern      = regexp.MustCompile("新興国株式")
-	emergingBondsPattern       = regexp.MustCompile("新興国債券")
-	emergingREITPattern        = regexp.MustCompile("新興国REIT")
-	balancePattern             = regexp.MustCompile("バランス")
-	comodity                   = regexp.MustCompile("コモディティ")
-	hedgeFundPattern           = regexp.MustCompile("ヘッジファンド")
-	bullBearPattern            = regexp.MustCompile("ブル・ベア")
-)
-
+// String 表示名
 func (c AssetClass) String() string {
-	switch c {
-	default:
-		return "その他"
-	case DomesticStocks:
-		return "国内株式"
-	case DomesticBonds:
-		return "国内債券"
-	case DomesticREIT:
-		return "国内REIT"
-	case InternationalStocks:
-		return "海外株式"
-	case InternationalBonds:
-		return "海外債券"
-	case InternationalREIT:
-		return "海外REIT"
-	case EmergingStocks:
-		return "新興国株式"
-	case EmergingBonds:
-		return "新興国債券"
-	case EmergingREIT:
-		return "新興国REIT"
-	case Balance:
-		return "バランス"
-	case Comodity:
-		return "コモディティ"
-	case HedgeFund:
-		return "ヘッジファンド"
-	case BullBear:
-		return "ブルベア"
+	return c.Definition().Name
+}
+
+// Key 設定ファイルなどで使う識別子
+func (c AssetClass) Key() string {
+	return c.Definition().Key
+}
+
+// ParseAssetClassName 識別子(DomesticStocks)、表示名(国内株式)または英語の表示名(Domestic Stocks)からアセットクラスを得る
+func ParseAssetClassName(s string) (AssetClass, error) {
+	en := newPrinter(language.English)
+	for _, c := range AssetClasses() {
+		if s == c.Key() || s == c.String() || s == en.class(c) {
+			return c, nil
+		}
+	}
+	return Other, errors.Errorf("unknown asset class: %s", s)
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (c AssetClass) MarshalText() ([]byte, error) {
+	return []byte(c.Key()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (c *AssetClass) UnmarshalText(text []byte) error {
+	class, err := ParseAssetClassName(string(text))
+	if err != nil {
+		return err
+	}
+	*c = class
+	return nil
+}
+
+// UnmarshalJSON 以前のキャッシュに書かれた数値形式も読めるようにする
+// 数値は登録されているアセットクラスに限る
+func (c *AssetClass) UnmarshalJSON(data []byte) error {
+	if n, err := strconv.Atoi(string(data)); err == nil {
+		if !AssetClass(n).registered() {
+			return errors.Errorf("unknown asset class: %s", data)
+		}
+		*c = AssetClass(n)
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return errors.Wrap(err, "asset class must be a string or a number")
+	}
+	return c.UnmarshalText([]byte(s))
+}
+
+// Composition バランスファンドなどの資産構成 (アセットクラス → 比率)
+type Composition map[AssetClass]float64
+
+// ParseComposition "DomesticStocks=25" のような文字列から資産構成を作る
+// 比率はNormalizeで正規化する
+func ParseComposition(args []string) (Composition, error) {
+	c := Composition{}
+
+	for _, arg := range args {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 {
+			return nil, errors.Errorf("invalid composition: %s", arg)
+		}
+
+		class, err := ParseAssetClassName(kv[0])
+		if err != nil {
+			return nil, err
+		}
+
+		w, err := strconv.ParseFloat(kv[1], 64)
+		if err != nil || !(w >= 0) || math.IsInf(w, 0) {
+			return nil, errors.Errorf("invalid weight: %s", arg)
+		}
+
+		c[class] += w
+	}
+
+	return c.Normalize()
+}
+
+// Normalize 比率の合計が1になるようにした資産構成を返す
+// 比率が0のアセットクラスは取り除き、負の比率があればエラーにする
+func (c Composition) Normalize() (Composition, error) {
+	sum := 0.0
+	for class, w := range c {
+		if !(w >= 0) || math.IsInf(w, 0) {
+			return nil, errors.Errorf("invalid weight: %s=%v", class.Key(), w)
+		}
+		sum += w
+	}
+
+	if sum == 0 {
+		return nil, errors.New("composition must have a positive weight")
 	}
+
+	normalized := Composition{}
+	for class, w := range c {
+		if w > 0 {
+			normalized[class] = w / sum
+		}
+	}
+
+	return normalized, nil
 }
 
-func parseAssetClass(s string) AssetClass {
-	switch {
-	default:
-		return Other
-	case domesticStocksPattern.MatchString(s):
-		return DomesticStocks
-	case domesticBondsPattern.MatchString(s):
-		return DomesticBonds
-	case domesticREITPattern.MatchString(s):
-		return DomesticREIT
-	case internationalStocksPattern.MatchString(s):
-		return InternationalStocks
-	case internationalBondsPattern.MatchString(s):
-		return InternationalBonds
-	case internationalREITPattern.MatchString(s):
-		return InternationalREIT
-	case emergingStocksPattern.MatchString(s):
-		return EmergingStocks
-	case emergingBondsPattern.MatchString(s):
-		return EmergingBonds
-	case emergingREITPattern.MatchString(s):
-		return EmergingStocks
-	case balancePattern.MatchString(s):
-		return Balance
-	case comodity.MatchString(s):
-		return Comodity
-	case hedgeFundPattern.MatchString(s):
-		return HedgeFund
-	case bullBearPattern.MatchString(s):
-		return BullBear
+// weights アセットクラスごとの比率を返す
+// 資産構成がなければ自身のアセットクラスに全額を割り当てる
+func (f *Fund) weights() Composition {
+	if len(f.Composition) == 0 {
+		return Composition{f.AssetClass: 1}
 	}
+	return f.Composition
 }
 
 type fundUnited struct {
@@ -223,24 +248,23 @@ type AssetClassDetail struct {
 	diffPrice    float64 // 差分金額
 	pl           float64 // P/L
 	funds        map[FundCode]*fundUnited
+	weights      map[FundCode]float64 // ファンドのうちこのアセットクラスに割り当てた比率
 }
 
-func newAssetClassDetail(fu *fundUnited) *AssetClassDetail {
+func newAssetClassDetail(class AssetClass) *AssetClassDetail {
 	return &AssetClassDetail{
-		class:  fu.AssetClass,
-		aprice: fu.AcquisitionPrice,
-		cprice: fu.CurrentPrice,
-		funds: map[FundCode]*fundUnited{
-			fu.Code: fu,
-		},
+		class:   class,
+		funds:   map[FundCode]*fundUnited{},
+		weights: map[FundCode]float64{},
 	}
 }
 
-func (d *AssetClassDetail) merge(fu *fundUnited) {
-	d.aprice += fu.AcquisitionPrice
-	d.cprice += fu.CurrentPrice
+func (d *AssetClassDetail) merge(fu *fundUnited, weight float64) {
+	d.aprice += fu.AcquisitionPrice * weight
+	d.cprice += fu.CurrentPrice * weight
 	d.pl = d.cprice/d.aprice - 1
 	d.funds[fu.Code] = fu
+	d.weights[fu.Code] = weight
 }
 
 // AllocationTarget 目標アロケーション
@@ -251,39 +275,49 @@ type AssetAllocation struct {
 	aprice  float64
 	cprice  float64
 	details map[AssetClass]*AssetClassDetail
+	target  *TargetNode
+	stocks  []*Stock // アセットアロケーションに含めない個別株
 }
 
-// keys 使用するアセットクラスだけを取り出す
-func (a *AssetAllocation) keys() []AssetClass {
-	k := make([]AssetClass, 0, len(a.details))
-
-	for c := range a.details {
-		k = append(k, c)
+// nodePrice 目標アロケーションのノード配下の取得金額と評価額
+func (a *AssetAllocation) nodePrice(n *TargetNode) (aprice, cprice float64) {
+	for _, class := range n.leaves() {
+		if d, e := a.details[class]; e {
+			aprice += d.aprice
+			cprice += d.cprice
+		}
 	}
-
-	return k
+	return
 }
 
+// merge ファンドを資産構成に従ってアセットクラスごとに振り分ける
 func (a *AssetAllocation) merge(fu *fundUnited) {
 	a.aprice += fu.AcquisitionPrice
 	a.cprice += fu.CurrentPrice
-	d, e := a.details[fu.AssetClass]
-	if e {
-		d.merge(fu)
-	} else {
-		a.details[fu.AssetClass] = newAssetClassDetail(fu)
+
+	for class, w := range fu.weights() {
+		d, e := a.details[class]
+		if !e {
+			d = newAssetClassDetail(class)
+			a.details[class] = d
+		}
+		d.merge(fu, w)
 	}
 }
 
-func fundsFromETF(stocks []*Stock) []*Fund {
-	etf := map[int]AssetClass{
-		1680: InternationalStocks,
-	}
+// etfClasses アセットアロケーションにファンドとして含めるETFのアセットクラス
+var etfClasses = map[int]AssetClass{
+	1680: InternationalStocks,
+}
 
+// stockClass 明細で個別株を表示するアセットクラス
+const stockClass = DomesticStocks
+
+func fundsFromETF(stocks []*Stock) []*Fund {
 	fs := []*Fund{}
 
 	for _, s := range stocks {
-		c, e := etf[s.Code]
+		c, e := etfClasses[s.Code]
 		if e {
 			fs = append(fs, &Fund{
 				Name:                 s.Name,
@@ -301,13 +335,39 @@ func fundsFromETF(stocks []*Stock) []*Fund {
 	return fs
 }
 
+// withETFFunds fundsにファンドとして扱うETFを加える
+// すでにfundsにあるETFは加えない
+func withETFFunds(stocks []*Stock, funds []*Fund) []*Fund {
+	inFunds := map[FundCode]bool{}
+	for _, f := range funds {
+		inFunds[f.Code] = true
+	}
+
+	all := append([]*Fund{}, funds...)
+	for _, f := range fundsFromETF(stocks) {
+		if !inFunds[f.Code] {
+			all = append(all, f)
+		}
+	}
+	return all
+}
+
+// stocksNotInETF ファンドとして扱わない個別株
+// アセットアロケーションには含めず、明細にだけ表示する
+func stocksNotInETF(stocks []*Stock) []*Stock {
+	ss := []*Stock{}
+	for _, s := range stocks {
+		if _, e := etfClasses[s.Code]; !e {
+			ss = append(ss, s)
+		}
+	}
+	return ss
+}
+
 func mergeStocksAndFunds(stocks []*Stock, funds []*Fund) map[FundCode]*fundUnited {
 	fundUniteds := map[FundCode]*fundUnited{}
 
-	funds = append([]*Fund{}, funds...)
-	funds = append(funds, fundsFromETF(stocks)...)
-
-	for _, f := range funds {
+	for _, f := range withETFFunds(stocks, funds) {
 		fu, e := fundUniteds[f.Code]
 		if e {
 			fu.merge(f)
@@ -334,18 +394,23 @@ func (a *AssetAllocation) calcRatio() {
 
 // NewAssetAllocation アセットアロケーション計算
 func NewAssetAllocation(stocks []*Stock, funds []*Fund, target AllocationTarget) AssetAllocation {
+	return NewAssetAllocationTree(stocks, funds, NewTargetTree(target))
+}
+
+// NewAssetAllocationTree 階層化した目標アロケーションでアセットアロケーション計算
+func NewAssetAllocationTree(stocks []*Stock, funds []*Fund, tree *TargetNode) AssetAllocation {
 	fundUniteds := mergeStocksAndFunds(stocks, funds)
+	target := tree.AllocationTarget()
 
 	a := AssetAllocation{
 		details: map[AssetClass]*AssetClassDetail{},
+		target:  tree,
+		stocks:  stocksNotInETF(stocks),
 	}
 
 	for class, t := range target {
-		a.details[class] = &AssetClassDetail{
-			class:       class,
-			targetRatio: t,
-			funds:       map[FundCode]*fundUnited{},
-		}
+		a.details[class] = newAssetClassDetail(class)
+		a.details[class].targetRatio = t
 	}
 
 	for _, fu := range fundUniteds {
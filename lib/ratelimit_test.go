@@ -0,0 +1,40 @@
+package yajirobe
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(20 * time.Millisecond)
+
+	start := time.Now()
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.Wait(context.Background())
+		}()
+	}
+	wg.Wait()
+
+	// 最初の1回はすぐに戻り、残りの3回はそれぞれ20msずつ待つ
+	if d := time.Since(start); d < 60*time.Millisecond {
+		t.Errorf("expected to wait at least 60ms but waited %v", d)
+	}
+}
+
+func TestRateLimiterCancel(t *testing.T) {
+	l := newRateLimiter(time.Hour)
+	l.Wait(context.Background())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	if err := l.Wait(ctx); err != context.Canceled {
+		t.Errorf("expected context.Canceled but got %v", err)
+	}
+}
@@ -5,6 +5,8 @@ import (
 )
 
 // RebalancingBuy リバランス購入 購入金額を調整し売却せずに積み立てながらリバランスする場合の計算
+// バランスファンドなど資産構成を持つファンドは、内訳のアセットクラスごとの評価額として計算する
+// 目標アロケーションが階層化されている場合は、上位の階層から順に不足分を配分する
 func (a *AssetAllocation) RebalancingBuy(cost float64) map[AssetClass]float64 {
 	// 1, 追加資金を入れた後のアセットアロケーションの目標金額を計算し、現在の評価額と差分をとる。
 	// 2, 目標の金額に不足している資産クラスについて、追加投資する。
@@ -38,50 +40,65 @@ func (a *AssetAllocation) RebalancingBuy(cost float64) map[AssetClass]float64 {
 	// 差分     -150 -220  -30
 	// 追加額    150  220   30
 
-	// 必要なアセットクラス
-	keys := a.keys()
+	adds := map[AssetClass]float64{}
+	a.distribute(a.target, a.cprice, cost, adds)
 
+	sum := 0.0
+	for c, v := range adds {
+		// 端数丸め
+		x := round(v)
+		adds[c] = x
+		sum += x
+	}
+
+	// 丸め誤差を足しておく
+	// 足す対象は、追加投資をするクラスのうち、AssetClasses順にみて先頭に出現するものと決めておく
+	if sum != cost {
+		for _, c := range AssetClasses() {
+			if v, e := adds[c]; e && v != 0 {
+				adds[c] += cost - sum
+				break
+			}
+		}
+	}
+
+	return adds
+}
+
+// distribute ノードへの追加資金を、子ノードの目標額に対する不足分の割合で配分する
+// 階層化した目標アロケーションでは上位のノードから順に配分を決める
+// cprice: ノード配下の評価額
+func (a *AssetAllocation) distribute(n *TargetNode, cprice, cost float64, adds map[AssetClass]float64) {
 	// 追加資金を入れた後の評価額
-	total := a.cprice + cost
+	total := cprice + cost
 
 	// 不足分合計
 	shortfail := 0.0
 
 	// 差分
-	diffs := make([]float64, len(keys))
-	for i, c := range keys {
-		d := a.details[c]
-		tp := d.targetRatio * total
-		sf := d.cprice - tp
+	prices := make([]float64, len(n.Children))
+	diffs := make([]float64, len(n.Children))
+	for i, child := range n.Children {
+		_, prices[i] = a.nodePrice(child)
+		tp := child.Ratio * total
+		sf := prices[i] - tp
 		diffs[i] = sf
 		if sf < 0 {
 			shortfail += -sf
 		}
 	}
 
-	sum := 0.0
-	adds := make(map[AssetClass]float64, len(keys))
 	for i, c := range diffs {
 		if c < 0 {
-			// 端数丸め
-			x := round(-c / shortfail * cost)
-			adds[keys[i]] = x
-			sum += x
-		}
-	}
-
-	// 丸め誤差を足しておく
-	// 足す対象は、追加投資をするクラスのうち、AssetClasses順にみて先頭に出現するものと決めておく
-	if sum != cost {
-		for _, c := range AssetClasses {
-			if v, e := adds[c]; e && v != 0 {
-				adds[c] += cost - sum
-				break
+			x := -c / shortfail * cost
+			child := n.Children[i]
+			if child.IsLeaf() {
+				adds[child.Class] += x
+			} else {
+				a.distribute(child, prices[i], x, adds)
 			}
 		}
 	}
-
-	return adds
 }
 
 func round(n float64) float64 {
@@ -125,3 +125,108 @@ func TestRound(t *testing.T) {
 	assert(3, 2.6)
 	assert(3, 3)
 }
+
+func TestLookThrough(t *testing.T) {
+	balance := newFund(Balance, 400)
+	balance.Composition = Composition{
+		DomesticStocks:      0.5,
+		InternationalStocks: 0.5,
+	}
+
+	funds := []*Fund{
+		balance,
+		newFund(EmergingStocks, 200),
+		newFund(InternationalStocks, 400),
+	}
+
+	target := AllocationTarget{
+		EmergingStocks:      0.25,
+		DomesticStocks:      0.30,
+		InternationalStocks: 0.45,
+	}
+
+	a := NewAssetAllocation([]*Stock{}, funds, target)
+
+	if _, e := a.details[Balance]; e {
+		t.Errorf("expected Balance is decomposed but got %v", a.details[Balance].cprice)
+	}
+
+	if a.details[DomesticStocks].cprice != 200 {
+		t.Errorf("DomesticStocks expected 200 but got %.2f", a.details[DomesticStocks].cprice)
+	}
+
+	if a.details[InternationalStocks].cprice != 600 {
+		t.Errorf("InternationalStocks expected 600 but got %.2f", a.details[InternationalStocks].cprice)
+	}
+
+	// Test1と同じ配分になる
+	result := a.RebalancingBuy(100)
+
+	assert := makeAssert(t, result)
+
+	assert(EmergingStocks, 37)
+	assert(DomesticStocks, 63)
+	assert(InternationalStocks, 0)
+}
+
+func TestParseComposition(t *testing.T) {
+	c, err := ParseComposition([]string{"DomesticStocks=25", "国内債券=75"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c[DomesticStocks] != 0.25 || c[DomesticBonds] != 0.75 {
+		t.Errorf("expected normalized composition but got %v", c)
+	}
+
+	// 比率が0のアセットクラスは含めない
+	c, err = ParseComposition([]string{"DomesticStocks=1", "DomesticBonds=0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, e := c[DomesticBonds]; e || c[DomesticStocks] != 1 {
+		t.Errorf("expected zero weights to be dropped but got %v", c)
+	}
+
+	for _, args := range [][]string{
+		{"DomesticStocks"},
+		{"DomesticStocks=-1", "DomesticBonds=2"},
+		{"DomesticStocks=NaN"},
+		{"DomesticStocks=0"},
+	} {
+		if _, err := ParseComposition(args); err == nil {
+			t.Errorf("%v: expected error but got nil", args)
+		}
+	}
+}
+
+func TestTopDown(t *testing.T) {
+	funds := []*Fund{
+		newFund(DomesticStocks, 100),
+		newFund(InternationalStocks, 500),
+		newFund(DomesticBonds, 400),
+	}
+
+	tree := &TargetNode{
+		Ratio: 1,
+		Children: []*TargetNode{
+			{Name: "Stocks", Ratio: 0.7, Children: []*TargetNode{
+				{Class: DomesticStocks, Ratio: 0.3},
+				{Class: InternationalStocks, Ratio: 0.7},
+			}},
+			{Name: "Bonds", Ratio: 0.3, Children: []*TargetNode{
+				{Class: DomesticBonds, Ratio: 1},
+			}},
+		},
+	}
+
+	a := NewAssetAllocationTree([]*Stock{}, funds, tree)
+	result := a.RebalancingBuy(100)
+
+	assert := makeAssert(t, result)
+
+	// 株式全体が不足しているので全額株式に配分し、株式の中では国内株式だけが不足している
+	assert(DomesticStocks, 100)
+	assert(InternationalStocks, 0)
+	assert(DomesticBonds, 0)
+}
@@ -0,0 +1,182 @@
+package yajirobe
+
+import (
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// AssetClassDefinition アセットクラスの定義
+type AssetClassDefinition struct {
+	Key      string   `json:"key"`                // 設定ファイルなどで使う識別子
+	Name     string   `json:"name"`               // 表示名
+	Order    int      `json:"order"`              // 表示順
+	Patterns []string `json:"patterns,omitempty"` // 商品分類にマッチする正規表現
+}
+
+// firstCustomAssetClass 利用者が定義したアセットクラスに割り当てる最初の値
+const firstCustomAssetClass = AssetClass(100)
+
+var builtinAssetClasses = map[AssetClass]AssetClassDefinition{
+	DomesticStocks:      {Key: "DomesticStocks", Name: "国内株式", Order: 10},
+	InternationalStocks: {Key: "InternationalStocks", Name: "海外株式", Order: 20},
+	EmergingStocks:      {Key: "EmergingStocks", Name: "新興国株式", Order: 30},
+	DomesticBonds:       {Key: "DomesticBonds", Name: "国内債券", Order: 40},
+	InternationalBonds:  {Key: "InternationalBonds", Name: "海外債券", Order: 50},
+	EmergingBonds:       {Key: "EmergingBonds", Name: "新興国債券", Order: 60},
+	DomesticREIT:        {Key: "DomesticREIT", Name: "国内REIT", Order: 70},
+	InternationalREIT:   {Key: "InternationalREIT", Name: "海外REIT", Order: 80},
+	EmergingREIT:        {Key: "EmergingREIT", Name: "新興国REIT", Order: 90},
+	Balance:             {Key: "Balance", Name: "バランス", Order: 100},
+	Comodity:            {Key: "Commodity", Name: "コモディティ", Order: 110},
+	HedgeFund:           {Key: "HedgeFund", Name: "ヘッジファンド", Order: 120},
+	BullBear:            {Key: "BullBear", Name: "ブルベア", Order: 130},
+	Other:               {Key: "Other", Name: "その他", Order: 1000},
+}
+
+// registry 登録されているアセットクラス
+// LoadConfigで登録するのと同時にWebサーバーや先読みのgoroutineから読むので、
+// registryとassetClassesはregistryMuで守る
+var (
+	registryMu   sync.RWMutex
+	registry     map[AssetClass]AssetClassDefinition
+	assetClasses []AssetClass
+)
+
+func init() {
+	resetRegistry()
+}
+
+// resetRegistry 組み込みのアセットクラスだけの状態に戻す
+func resetRegistry() {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	registry = map[AssetClass]AssetClassDefinition{}
+	for c, def := range builtinAssetClasses {
+		registry[c] = def
+	}
+	sortAssetClasses()
+}
+
+// AssetClasses アセットクラス一覧 (表示順)
+// RegisterAssetClassで登録したアセットクラスも含む
+func AssetClasses() []AssetClass {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	return append([]AssetClass{}, assetClasses...)
+}
+
+// sortAssetClasses registryからassetClassesを作り直す registryMuを持って呼ぶ
+func sortAssetClasses() {
+	classes := make([]AssetClass, 0, len(registry))
+	for c := range registry {
+		classes = append(classes, c)
+	}
+
+	sort.Slice(classes, func(i, j int) bool {
+		oi, oj := registry[classes[i]].Order, registry[classes[j]].Order
+		if oi != oj {
+			return oi < oj
+		}
+		return classes[i] < classes[j]
+	})
+
+	assetClasses = classes
+}
+
+// Definition アセットクラスの定義
+// 登録されていなければOtherの定義を返す
+func (c AssetClass) Definition() AssetClassDefinition {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	if def, e := registry[c]; e {
+		return def
+	}
+	return registry[Other]
+}
+
+// registered 登録されているアセットクラスか
+func (c AssetClass) registered() bool {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	_, e := registry[c]
+	return e
+}
+
+// RegisterAssetClass アセットクラスを登録する
+// 登録済みの識別子なら定義を上書きして同じアセットクラスを返す
+func RegisterAssetClass(def AssetClassDefinition) (AssetClass, error) {
+	if def.Key == "" {
+		return Other, errors.New("asset class key must not be empty")
+	}
+
+	if def.Name == "" {
+		def.Name = def.Key
+	}
+
+	for _, p := range def.Patterns {
+		if _, err := regexp.Compile(p); err != nil {
+			return Other, errors.Wrapf(err, "asset class %s: invalid pattern", def.Key)
+		}
+	}
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	class := firstCustomAssetClass
+	for c, d := range registry {
+		if d.Key == def.Key {
+			class = c
+			break
+		}
+		if c >= class {
+			class = c + 1
+		}
+	}
+
+	registry[class] = def
+	sortAssetClasses()
+
+	return class, nil
+}
+
+// RegisterAssetClasses 複数のアセットクラスを登録する
+func RegisterAssetClasses(defs []AssetClassDefinition) error {
+	for _, def := range defs {
+		if _, err := RegisterAssetClass(def); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// registeredRules 登録されたアセットクラスの商品分類パターンから作った分類ルール
+// 利用者が定義したアセットクラスは登録順に並ぶ
+func registeredRules() []ClassificationRule {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	classes := make([]AssetClass, 0, len(registry))
+	for c, def := range registry {
+		if len(def.Patterns) > 0 {
+			classes = append(classes, c)
+		}
+	}
+
+	sort.Slice(classes, func(i, j int) bool { return classes[i] < classes[j] })
+
+	rules := []ClassificationRule{}
+	for _, c := range classes {
+		for _, p := range registry[c].Patterns {
+			rules = append(rules, ClassificationRule{Category: p, Class: c})
+		}
+	}
+
+	return rules
+}
@@ -0,0 +1,136 @@
+package yajirobe
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestRegisterAssetClass(t *testing.T) {
+	defer resetRegistry()
+
+	gold, err := RegisterAssetClass(AssetClassDefinition{
+		Key:      "Gold",
+		Name:     "金",
+		Order:    115,
+		Patterns: []string{"ゴールド"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if gold < firstCustomAssetClass {
+		t.Errorf("expected a custom asset class but got %d", gold)
+	}
+
+	if gold.String() != "金" || gold.Key() != "Gold" {
+		t.Errorf("unexpected definition: %v", gold.Definition())
+	}
+
+	again, err := RegisterAssetClass(AssetClassDefinition{Key: "Gold", Name: "金", Order: 115, Patterns: []string{"ゴールド"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != gold {
+		t.Errorf("expected the same asset class %d but got %d", gold, again)
+	}
+
+	// 表示順はコモディティとヘッジファンドの間
+	classes := AssetClasses()
+	for i, c := range classes {
+		if c == gold {
+			if classes[i-1] != Comodity || classes[i+1] != HedgeFund {
+				t.Errorf("unexpected order: %v", classes)
+			}
+		}
+	}
+
+	var c AssetClass
+	if err := json.Unmarshal([]byte(`"Gold"`), &c); err != nil || c != gold {
+		t.Errorf("expected %d but got %d (%v)", gold, c, err)
+	}
+
+	classifier, err := (&Config{}).Classifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if class := classifier.Classify("コモディティ/ゴールド", "SMT ゴールドインデックス・オープン"); class != gold {
+		t.Errorf("expected %v but got %v", gold, class)
+	}
+}
+
+func TestRegisterAssetClassInvalid(t *testing.T) {
+	defer resetRegistry()
+
+	if _, err := RegisterAssetClass(AssetClassDefinition{}); err == nil {
+		t.Error("expected error for an empty key")
+	}
+
+	if _, err := RegisterAssetClass(AssetClassDefinition{Key: "Invalid", Patterns: []string{"("}}); err == nil {
+		t.Error("expected error for an invalid pattern")
+	}
+}
+
+func TestCustomAssetClassRebalance(t *testing.T) {
+	defer resetRegistry()
+
+	smallCap, err := RegisterAssetClass(AssetClassDefinition{Key: "JapanSmallCap", Name: "国内小型株", Order: 15})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	funds := []*Fund{
+		newFund(DomesticStocks, 200),
+		newFund(smallCap, 200),
+		newFund(InternationalStocks, 600),
+	}
+
+	target := AllocationTarget{
+		DomesticStocks:      0.25,
+		smallCap:            0.30,
+		InternationalStocks: 0.45,
+	}
+
+	a := NewAssetAllocation([]*Stock{}, funds, target)
+	result := a.RebalancingBuy(100)
+
+	assert := makeAssert(t, result)
+
+	assert(DomesticStocks, 37)
+	assert(smallCap, 63)
+	assert(InternationalStocks, 0)
+}
+
+func TestRegisterAssetClassConcurrent(t *testing.T) {
+	defer resetRegistry()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := RegisterAssetClass(AssetClassDefinition{Key: fmt.Sprintf("Custom%d", i)}); err != nil {
+				t.Error(err)
+			}
+		}(i)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, c := range AssetClasses() {
+				_ = c.String()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := len(AssetClasses()); n != len(builtinAssetClasses)+4 {
+		t.Errorf("expected %d asset classes but got %d", len(builtinAssetClasses)+4, n)
+	}
+
+	resetRegistry()
+	if n := len(AssetClasses()); n != len(builtinAssetClasses) {
+		t.Errorf("expected only the builtin asset classes after reset but got %d", n)
+	}
+}
@@ -2,77 +2,354 @@ package yajirobe
 
 import (
 	"fmt"
+	"io"
 	"os"
 
 	"github.com/olekukonko/tablewriter"
-	"golang.org/x/text/message"
+	"github.com/pkg/errors"
+	"golang.org/x/text/language"
 )
 
-func renderStocks(sx []Stock) {
-	table := tablewriter.NewWriter(os.Stdout)
-	p := message.NewPrinter(message.MatchLanguage("en"))
+// Renderer アセットアロケーションを書き出す
+// buyはRebalancingBuyの結果で、なければnil
+type Renderer interface {
+	Render(w io.Writer, a *AssetAllocation, buy map[AssetClass]float64) error
+}
+
+// RenderFormats NewRendererで使える形式
+var RenderFormats = []string{"table", "json", "csv", "markdown", "html", "tsv"}
 
-	table.SetHeader([]string{"Name", "Current", "P/L"})
-	table.SetColumnAlignment([]int{
-		tablewriter.ALIGN_DEFAULT,
-		tablewriter.ALIGN_RIGHT,
-		tablewriter.ALIGN_RIGHT,
-	})
+// RenderOption Rendererの設定
+type RenderOption struct {
+	Funds bool         // ファンドごとの明細も書き出す
+	Lang  language.Tag // 出力に使う言語 language.Undなら以前と同じ表示
+}
 
-	for _, s := range sx {
-		table.Append([]string{s.Name, p.Sprintf("%d", s.CurrentPrice), p.Sprintf("%.1f%%", s.ProfitAndLossRatio()*100)})
+// NewRenderer 形式の名前からRendererを作る
+func NewRenderer(format string, option RenderOption) (Renderer, error) {
+	switch format {
+	case "table":
+		return &TableRenderer{option}, nil
+	case "json":
+		return &JSONRenderer{option}, nil
+	case "csv":
+		return &CSVRenderer{option}, nil
+	case "markdown":
+		return &MarkdownRenderer{option}, nil
+	case "html":
+		return &HTMLRenderer{option}, nil
+	case "tsv":
+		return &TSVRenderer{option}, nil
+	default:
+		return nil, errors.Errorf("unknown format: %s", format)
 	}
-	table.Render()
 }
 
-// Render 画面に書き出す
-func (a *AssetAllocation) Render() {
-	table := tablewriter.NewWriter(os.Stdout)
-	p := message.NewPrinter(message.MatchLanguage("en"))
+// alignments 表の列の寄せ方 (先頭列だけ左寄せ)
+func alignments(n int) []int {
+	a := make([]int, n)
+	for i := range a {
+		a[i] = tablewriter.ALIGN_RIGHT
+	}
+	a[0] = tablewriter.ALIGN_DEFAULT
+	return a
+}
 
-	//table.SetAutoMergeCells(true)
-	table.SetHeader([]string{
+func allocationHeader(p *printer) []string {
+	return p.all(
 		"Class",
 		"Target",
 		"Actual",
 		"Current",
 		"Diff",
 		"P/L",
-	})
-	table.SetColumnAlignment([]int{
-		tablewriter.ALIGN_DEFAULT,
-		tablewriter.ALIGN_RIGHT,
-		tablewriter.ALIGN_RIGHT,
-		tablewriter.ALIGN_RIGHT,
-		tablewriter.ALIGN_RIGHT,
-		tablewriter.ALIGN_RIGHT,
-	})
-
-	table.Append([]string{
-		"全体", // Class
-		"",   // Target
-		"",   // Actual
+	)
+}
+
+// allocationRows 全体とアセットクラスごとの行
+// 目標アロケーションが階層化されていれば小計の行も含める
+func (a *AssetAllocation) allocationRows(p *printer) [][]string {
+	rows := [][]string{{
+		p.Sprintf("Total"),          // Class
+		"",                          // Target
+		"",                          // Actual
 		p.Sprintf("%.2f", a.cprice), // Current
-		"", // Diff
+		"",                          // Diff
 		p.Sprintf("%.1f%%", a.cprice/a.aprice*100-100), // P/L
-	})
+	}}
 
-	for _, class := range AssetClasses {
+	if a.target != nil && a.target.IsNested() {
+		return a.appendNodeRows(rows, p, a.target, 1, "")
+	}
+
+	for _, class := range AssetClasses() {
 		detail, e := a.details[class]
 		if !e {
 			continue
 		}
 
-		row := []string{
-			class.String(),                                 // Class
-			fmt.Sprintf("%.1f%%", detail.targetRatio*100),  // Target
-			fmt.Sprintf("%.1f%%", detail.currentRatio*100), // Actual
-			p.Sprintf("%.2f", detail.cprice),               // Current
-			p.Sprintf("%.0f", detail.diffPrice),            // Diff
-			p.Sprintf("%.1f%%", detail.pl*100),             // P/L
+		rows = append(rows, detailRow(p, detail))
+	}
+
+	return rows
+}
+
+func detailRow(p *printer, detail *AssetClassDetail) []string {
+	return []string{
+		p.class(detail.class),                          // Class
+		fmt.Sprintf("%.1f%%", detail.targetRatio*100),  // Target
+		fmt.Sprintf("%.1f%%", detail.currentRatio*100), // Actual
+		p.Sprintf("%.2f", detail.cprice),               // Current
+		p.Sprintf("%.0f", detail.diffPrice),            // Diff
+		p.Sprintf("%.1f%%", detail.pl*100),             // P/L
+	}
+}
+
+// appendNodeRows 階層化した目標アロケーションの小計と明細を追加する
+// ratio: ノードの全体に対する目標割合
+func (a *AssetAllocation) appendNodeRows(rows [][]string, p *printer, n *TargetNode, ratio float64, indent string) [][]string {
+	for _, child := range n.Children {
+		r := ratio * child.Ratio
+		aprice, cprice := a.nodePrice(child)
+		pl := 0.0
+		if aprice != 0 {
+			pl = cprice/aprice - 1
+		}
+
+		rows = append(rows, []string{
+			indent + p.label(child),                    // Class
+			fmt.Sprintf("%.1f%%", r*100),               // Target
+			fmt.Sprintf("%.1f%%", cprice/a.cprice*100), // Actual
+			p.Sprintf("%.2f", cprice),                  // Current
+			p.Sprintf("%.0f", cprice-r*a.cprice),       // Diff
+			p.Sprintf("%.1f%%", pl*100),                // P/L
+		})
+
+		if !child.IsLeaf() {
+			rows = a.appendNodeRows(rows, p, child, r, indent+"  ")
+		}
+	}
+
+	if n != a.target {
+		return rows
+	}
+
+	// 目標アロケーションにないアセットクラス
+	target := n.AllocationTarget()
+	for _, class := range AssetClasses() {
+		detail, e := a.details[class]
+		if _, t := target[class]; !e || t {
+			continue
+		}
+
+		rows = append(rows, detailRow(p, detail))
+	}
+
+	return rows
+}
+
+func fundHeader(p *printer) []string {
+	return p.all(
+		"Class / Fund",
+		"Amount",
+		"Acquisition Unit",
+		"Current Unit",
+		"Current",
+		"P/L",
+		"P/L%%",
+	)
+}
+
+func fundRow(p *printer, name string, f *Fund) []string {
+	return []string{
+		name,                      // Class / Fund
+		p.Sprintf("%d", f.Amount), // Amount
+		p.Sprintf("%.0f", f.AcquisitionUnitPrice),               // Acquisition Unit
+		p.Sprintf("%.0f", f.CurrentUnitPrice),                   // Current Unit
+		p.Sprintf("%.2f", f.CurrentPrice),                       // Current
+		p.Sprintf("%.0f", f.ProfitAndLoss()),                    // P/L
+		p.Sprintf("%.1f%%", finite(f.ProfitAndLossRatio())*100), // P/L%
+	}
+}
+
+// stockRow 個別株の行
+// 個別株はアセットアロケーションに含めないので、名前にそのことを書き添える
+func stockRow(p *printer, s *Stock) []string {
+	return []string{
+		"  " + p.Sprintf("%s (not in allocation)", s.Name),      // Class / Fund
+		p.Sprintf("%d", s.Amount),                               // Amount
+		p.Sprintf("%d", s.AcquisitionUnitPrice),                 // Acquisition Unit
+		p.Sprintf("%d", s.CurrentUnitPrice),                     // Current Unit
+		p.Sprintf("%d", s.CurrentPrice),                         // Current
+		p.Sprintf("%d", s.ProfitAndLoss()),                      // P/L
+		p.Sprintf("%.1f%%", finite(s.ProfitAndLossRatio())*100), // P/L%
+	}
+}
+
+// fundRows アセットクラスごとの小計と、含まれるファンドと個別株の明細の行
+// 複数の保有分を合算したファンドは合算元の行も含める
+func (a *AssetAllocation) fundRows(p *printer) [][]string {
+	rows := [][]string{}
+
+	for _, c := range AssetClasses() {
+		d, e := a.details[c]
+		hasFunds := e && len(d.funds) > 0
+		hasStocks := c == stockClass && len(a.stocks) > 0
+		if !hasFunds && !hasStocks {
+			continue
+		}
+
+		if e {
+			rows = append(rows, []string{
+				p.class(d.Class()),                  // Class / Fund
+				"",                                  // Amount
+				"",                                  // Acquisition Unit
+				"",                                  // Current Unit
+				p.Sprintf("%.2f", d.CurrentPrice()), // Current
+				p.Sprintf("%.0f", d.CurrentPrice()-d.AcquisitionPrice()), // P/L
+				p.Sprintf("%.1f%%", finite(d.ProfitAndLossRatio())*100),  // P/L%
+			})
+		} else {
+			rows = append(rows, []string{p.class(c), "", "", "", "", "", ""})
 		}
-		table.Append(row)
+
+		if hasFunds {
+			for _, h := range d.Funds() {
+				name := "  " + h.Name
+				if h.Weight != 1 {
+					name += p.Sprintf(" (%.1f%%)", h.Weight*100)
+				}
+				rows = append(rows, fundRow(p, name, &h.Fund))
+
+				if len(h.Sources) < 2 {
+					continue
+				}
+				for i := range h.Sources {
+					rows = append(rows, fundRow(p, p.Sprintf("    #%d", i+1), &h.Sources[i]))
+				}
+			}
+		}
+
+		if hasStocks {
+			for _, s := range a.stocks {
+				rows = append(rows, stockRow(p, s))
+			}
+		}
+	}
+
+	return rows
+}
+
+func buyHeader(p *printer) []string {
+	return p.all("Class", "Buy")
+}
+
+// buyRows RebalancingBuyの結果の行
+func buyRows(p *printer, buy map[AssetClass]float64) [][]string {
+	rows := [][]string{}
+
+	for _, c := range AssetClasses() {
+		if v, e := buy[c]; e {
+			rows = append(rows, []string{p.class(c), money(p, v)})
+		}
+	}
+
+	return rows
+}
+
+func renderTable(w io.Writer, header []string, rows [][]string) {
+	table := tablewriter.NewWriter(w)
+	table.SetHeader(header)
+	table.SetColumnAlignment(alignments(len(header)))
+	table.AppendBulk(rows)
+	table.Render()
+}
+
+// TableRenderer 罫線つきの表で書き出す
+type TableRenderer struct {
+	RenderOption
+}
+
+// Render implements Renderer
+func (r *TableRenderer) Render(w io.Writer, a *AssetAllocation, buy map[AssetClass]float64) error {
+	p := newPrinter(r.Lang)
+
+	renderTable(w, allocationHeader(p), a.allocationRows(p))
+
+	if r.Funds {
+		renderTable(w, fundHeader(p), a.fundRows(p))
+	}
+
+	for _, c := range AssetClasses() {
+		if v, e := buy[c]; e {
+			if _, err := p.Fprintf(w, "%s\t%10s\n", p.class(c), money(p, v)); err != nil {
+				return errors.Wrap(err, "can't write buy")
+			}
+		}
+	}
+
+	return nil
+}
+
+// Render 画面に書き出す
+func (a *AssetAllocation) Render() {
+	(&TableRenderer{}).Render(os.Stdout, a, nil)
+}
+
+// RenderClassOverrides ファンドごとにSBIの商品分類によるアセットクラスと実際に使うアセットクラスをlangで書き出す
+func RenderClassOverrides(w io.Writer, funds []*Fund, overrides ClassOverrides, lang language.Tag) {
+	p := newPrinter(lang)
+	rows := [][]string{}
+
+	for _, r := range NewFundClassReports(funds, overrides) {
+		mark := ""
+		if r.Scraped != r.Effective {
+			mark = " *"
+		}
+
+		scraped, _ := ParseAssetClassName(r.Scraped)
+		effective, _ := ParseAssetClassName(r.Effective)
+
+		rows = append(rows, []string{
+			r.Code,                    // Code
+			r.Name,                    // Name
+			p.class(scraped),          // Scraped
+			p.class(effective) + mark, // Effective
+		})
+	}
+
+	table := tablewriter.NewWriter(w)
+	table.SetHeader(p.all("Code", "Name", "Scraped", "Effective"))
+	table.AppendBulk(rows)
+	table.Render()
+}
+
+// RenderCacheEntries cache listの出力をlangの表で書き出す
+func RenderCacheEntries(w io.Writer, entries []CacheEntry, lang language.Tag) {
+	p := newPrinter(lang)
+	rows := [][]string{}
+
+	for _, e := range entries {
+		class, _ := ParseAssetClassName(e.Class)
+		updatedAt := "-"
+		if !e.UpdatedAt.IsZero() {
+			updatedAt = e.UpdatedAt.Local().Format("2006-01-02 15:04")
+		}
+		if e.Expired {
+			updatedAt += " *"
+		}
+
+		rows = append(rows, []string{
+			e.Code,         // Code
+			e.Name,         // Name
+			p.class(class), // Class
+			e.Category,     // Category
+			updatedAt,      // Updated
+		})
 	}
 
+	table := tablewriter.NewWriter(w)
+	table.SetHeader(p.all("Code", "Name", "Class", "Category", "Updated"))
+	table.AppendBulk(rows)
 	table.Render()
 }
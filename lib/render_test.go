@@ -0,0 +1,132 @@
+package yajirobe
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/language"
+)
+
+func TestRenderers(t *testing.T) {
+	a := testAllocation()
+	buy := a.RebalancingBuy(100)
+
+	render := func(format string, lang language.Tag) string {
+		r, err := NewRenderer(format, RenderOption{Lang: lang})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		buf := &bytes.Buffer{}
+		if err := r.Render(buf, &a, buy); err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		return buf.String()
+	}
+
+	for _, test := range []struct {
+		lang     language.Tag
+		format   string
+		contains []string
+	}{
+		{language.Japanese, "table", []string{"| 国内株式", "20.0%", "国内株式\t       63円"}},
+		{language.Japanese, "markdown", []string{"| アセットクラス | 目標 |", "| --- | ---: |", "| 国内株式 | 30.0% | 20.0% | 200.00 | -100 | 100.0% |", "| 国内株式 | 63円 |"}},
+		{language.Japanese, "html", []string{"<th>アセットクラス</th>", "<td>国内株式</td><td align=\"right\">30.0%</td>", "yajirobe-buy"}},
+		{language.Japanese, "tsv", []string{"アセットクラス\t目標\t実際\t評価額\t差額\t損益\n", "国内株式\t30.0%\t20.0%\t200.00\t-100\t100.0%\n", "国内株式\t63円\n"}},
+		{language.English, "table", []string{"| Domestic Stocks", "20.0%", "Domestic Stocks\t       ¥63"}},
+		{language.English, "tsv", []string{"Class\tTarget\tActual\tCurrent\tDiff\tP/L\n", "Domestic Stocks\t30.0%\t20.0%\t200.00\t-100\t100.0%\n", "Domestic Stocks\t¥63\n"}},
+		{language.English, "json", []string{`"class": "DomesticStocks"`, `"name": "国内株式"`, `"amount": 63`}},
+		{language.English, "csv", []string{"DomesticStocks,国内株式,0.3,0.2"}},
+		// 言語を指定しなければ以前と同じく英語の見出しと日本語のアセットクラス名
+		{language.Und, "tsv", []string{"Class\tTarget\tActual\tCurrent\tDiff\tP/L\n", "国内株式\t30.0%\t20.0%\t200.00\t-100\t100.0%\n"}},
+	} {
+		out := render(test.format, test.lang)
+		for _, c := range test.contains {
+			if !strings.Contains(out, c) {
+				t.Errorf("%s (%v): expected to contain %q but got\n%s", test.format, test.lang, c, out)
+			}
+		}
+	}
+
+	// 機械可読な形式は言語によって変わらない
+	for _, format := range []string{"json", "csv"} {
+		if ja, en := render(format, language.Japanese), render(format, language.English); ja != en {
+			t.Errorf("%s: expected the same output in every language but got\n%s\n%s", format, ja, en)
+		}
+	}
+
+	if _, err := NewRenderer("unknown", RenderOption{}); err == nil {
+		t.Error("expected error for an unknown format")
+	}
+}
+
+func TestRenderFunds(t *testing.T) {
+	f1 := newFund(DomesticStocks, 100)
+	f1.Name = "国内株式ファンド"
+	f1.Amount = 10000
+	f1.AcquisitionPrice = 80
+	f2 := newFund(DomesticStocks, 300)
+	f2.Name = "国内株式ファンド"
+	f2.Amount = 20000
+	f2.AcquisitionPrice = 200
+
+	a := NewAssetAllocation([]*Stock{}, []*Fund{f1, f2}, AllocationTarget{DomesticStocks: 1})
+
+	r, _ := NewRenderer("tsv", RenderOption{Funds: true, Lang: language.Japanese})
+	buf := &bytes.Buffer{}
+	if err := r.Render(buf, &a, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []string{
+		"アセットクラス / ファンド\t保有数量\t取得単価\t基準価額\t評価額\t損益\t損益率\n",
+		"国内株式\t\t\t\t400.00\t120\t42.9%\n",
+		"  国内株式ファンド\t30,000\t93\t133\t400.00\t120\t42.9%\n",
+		"    #1\t10,000\t0\t0\t100.00\t20\t25.0%\n",
+		"    #2\t20,000\t0\t0\t300.00\t100\t50.0%\n",
+	} {
+		if !strings.Contains(buf.String(), c) {
+			t.Errorf("expected to contain %q but got\n%s", c, buf.String())
+		}
+	}
+
+	report := a.Report()
+	if len(report.Classes[0].Funds[0].Sources) != 2 {
+		t.Errorf("expected sources in report but got %+v", report.Classes[0].Funds[0])
+	}
+}
+
+func TestRenderStocks(t *testing.T) {
+	stocks := []*Stock{
+		{Name: "トヨタ自動車", Code: 7203, Amount: 100, AcquisitionUnitPrice: 6000, CurrentUnitPrice: 7000, AcquisitionPrice: 600000, CurrentPrice: 700000},
+		{Name: "外国株式ETF", Code: 1680, Amount: 10, AcquisitionUnitPrice: 10, CurrentUnitPrice: 20, AcquisitionPrice: 100, CurrentPrice: 200},
+	}
+	f := newFund(InternationalStocks, 800)
+
+	a := NewAssetAllocation(stocks, []*Fund{f}, AllocationTarget{InternationalStocks: 1})
+
+	buf := &bytes.Buffer{}
+	r, _ := NewRenderer("tsv", RenderOption{Funds: true, Lang: language.English})
+	if err := r.Render(buf, &a, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// 個別株は配分に含めず国内株式の下に、ETFはファンドとしてアセットクラスの下に並べる
+	for _, c := range []string{
+		"Domestic Stocks\t\t\t\t\t\t\n  トヨタ自動車 (not in allocation)\t100\t6,000\t7,000\t700,000\t100,000\t16.7%\n",
+		"  外国株式ETF\t10\t",
+	} {
+		if !strings.Contains(buf.String(), c) {
+			t.Errorf("expected to contain %q but got\n%s", c, buf.String())
+		}
+	}
+
+	if strings.Contains(buf.String(), "外国株式ETF (not in allocation)") {
+		t.Errorf("expected the ETF to be listed only as a fund but got\n%s", buf.String())
+	}
+
+	if a.CurrentPrice() != 1000 {
+		t.Errorf("expected individual stocks to stay out of the allocation but got %v", a.CurrentPrice())
+	}
+}
@@ -0,0 +1,175 @@
+package yajirobe
+
+import (
+	"bufio"
+	"html"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// MarkdownRenderer Markdownの表で書き出す
+type MarkdownRenderer struct {
+	RenderOption
+}
+
+func writeMarkdownTable(w *bufio.Writer, header []string, rows [][]string) {
+	escape := func(s string) string {
+		return strings.Replace(s, "|", "\\|", -1)
+	}
+
+	line := func(cells []string) {
+		w.WriteString("|")
+		for _, c := range cells {
+			w.WriteString(" " + escape(c) + " |")
+		}
+		w.WriteString("\n")
+	}
+
+	line(header)
+
+	w.WriteString("|")
+	for i := range header {
+		if i == 0 {
+			w.WriteString(" --- |")
+		} else {
+			w.WriteString(" ---: |")
+		}
+	}
+	w.WriteString("\n")
+
+	for _, row := range rows {
+		line(row)
+	}
+}
+
+// Render implements Renderer
+func (r *MarkdownRenderer) Render(w io.Writer, a *AssetAllocation, buy map[AssetClass]float64) error {
+	p := newPrinter(r.Lang)
+	bw := bufio.NewWriter(w)
+
+	writeMarkdownTable(bw, allocationHeader(p), a.allocationRows(p))
+
+	if r.Funds {
+		bw.WriteString("\n")
+		writeMarkdownTable(bw, fundHeader(p), a.fundRows(p))
+	}
+
+	if buy != nil {
+		bw.WriteString("\n")
+		writeMarkdownTable(bw, buyHeader(p), buyRows(p, buy))
+	}
+
+	return errors.Wrap(bw.Flush(), "can't write markdown")
+}
+
+// HTMLRenderer HTMLのtable要素で書き出す
+type HTMLRenderer struct {
+	RenderOption
+}
+
+func writeHTMLTable(w *bufio.Writer, class string, header []string, rows [][]string) {
+	w.WriteString(`<table class="` + class + `">` + "\n<thead>\n<tr>")
+	for _, h := range header {
+		w.WriteString("<th>" + html.EscapeString(h) + "</th>")
+	}
+	w.WriteString("</tr>\n</thead>\n<tbody>\n")
+
+	for _, row := range rows {
+		w.WriteString("<tr>")
+		for i, c := range row {
+			if i == 0 {
+				w.WriteString("<td>")
+			} else {
+				w.WriteString(`<td align="right">`)
+			}
+			w.WriteString(html.EscapeString(c) + "</td>")
+		}
+		w.WriteString("</tr>\n")
+	}
+
+	w.WriteString("</tbody>\n</table>\n")
+}
+
+// Render implements Renderer
+func (r *HTMLRenderer) Render(w io.Writer, a *AssetAllocation, buy map[AssetClass]float64) error {
+	p := newPrinter(r.Lang)
+	bw := bufio.NewWriter(w)
+
+	writeHTMLTable(bw, "yajirobe-allocation", allocationHeader(p), a.allocationRows(p))
+
+	if r.Funds {
+		writeHTMLTable(bw, "yajirobe-funds", fundHeader(p), a.fundRows(p))
+	}
+
+	if buy != nil {
+		writeHTMLTable(bw, "yajirobe-buy", buyHeader(p), buyRows(p, buy))
+	}
+
+	return errors.Wrap(bw.Flush(), "can't write html")
+}
+
+// TSVRenderer タブ区切りで書き出す
+type TSVRenderer struct {
+	RenderOption
+}
+
+func writeTSV(w *bufio.Writer, header []string, rows [][]string) {
+	w.WriteString(strings.Join(header, "\t") + "\n")
+	for _, row := range rows {
+		w.WriteString(strings.Join(row, "\t") + "\n")
+	}
+}
+
+// Render implements Renderer
+func (r *TSVRenderer) Render(w io.Writer, a *AssetAllocation, buy map[AssetClass]float64) error {
+	p := newPrinter(r.Lang)
+	bw := bufio.NewWriter(w)
+
+	writeTSV(bw, allocationHeader(p), a.allocationRows(p))
+
+	if r.Funds {
+		bw.WriteString("\n")
+		writeTSV(bw, fundHeader(p), a.fundRows(p))
+	}
+
+	if buy != nil {
+		bw.WriteString("\n")
+		writeTSV(bw, buyHeader(p), buyRows(p, buy))
+	}
+
+	return errors.Wrap(bw.Flush(), "can't write tsv")
+}
+
+func newReport(a *AssetAllocation, buy map[AssetClass]float64) *Report {
+	report := a.Report()
+	if buy != nil {
+		report.SetBuy(buy)
+	}
+	return report
+}
+
+// JSONRenderer Reportの形式のJSONで書き出す
+type JSONRenderer struct {
+	RenderOption
+}
+
+// Render implements Renderer
+func (r *JSONRenderer) Render(w io.Writer, a *AssetAllocation, buy map[AssetClass]float64) error {
+	return WriteJSON(w, newReport(a, buy))
+}
+
+// CSVRenderer Reportの形式のCSVで書き出す
+// Fundsを指定した場合はファンドごとに1行で書き出す
+type CSVRenderer struct {
+	RenderOption
+}
+
+// Render implements Renderer
+func (r *CSVRenderer) Render(w io.Writer, a *AssetAllocation, buy map[AssetClass]float64) error {
+	if r.Funds {
+		return newReport(a, buy).WriteFundsCSV(w)
+	}
+	return newReport(a, buy).WriteCSV(w)
+}
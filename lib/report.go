@@ -0,0 +1,329 @@
+package yajirobe
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Report 機械可読な形式で出力するためのアセットアロケーション
+// 出力の形式が言語によって変わらないように、nameには翻訳しない表示名を使う
+type Report struct {
+	AcquisitionPrice   float64       `json:"acquisition_price"`
+	CurrentPrice       float64       `json:"current_price"`
+	ProfitAndLoss      float64       `json:"profit_and_loss"`
+	ProfitAndLossRatio float64       `json:"profit_and_loss_ratio"`
+	Classes            []ClassReport `json:"classes"`
+	Buy                []BuyReport   `json:"buy,omitempty"`
+}
+
+// ClassReport アセットクラスごとの明細
+type ClassReport struct {
+	Class              string       `json:"class"`
+	Name               string       `json:"name"`
+	TargetRatio        float64      `json:"target_ratio"`
+	CurrentRatio       float64      `json:"current_ratio"`
+	TargetPrice        float64      `json:"target_price"`
+	AcquisitionPrice   float64      `json:"acquisition_price"`
+	CurrentPrice       float64      `json:"current_price"`
+	Diff               float64      `json:"diff"`
+	ProfitAndLossRatio float64      `json:"profit_and_loss_ratio"`
+	Funds              []FundReport `json:"funds"`
+}
+
+// FundReport アセットクラスに含まれるファンドの明細
+// Weightはファンドのうちこのアセットクラスに割り当てた比率
+type FundReport struct {
+	Code                 string       `json:"code"`
+	Name                 string       `json:"name"`
+	Weight               float64      `json:"weight"`
+	Amount               int          `json:"amount"`
+	AcquisitionUnitPrice float64      `json:"acquisition_unit_price"`
+	CurrentUnitPrice     float64      `json:"current_unit_price"`
+	AcquisitionPrice     float64      `json:"acquisition_price"`
+	CurrentPrice         float64      `json:"current_price"`
+	ProfitAndLoss        float64      `json:"profit_and_loss"`
+	ProfitAndLossRatio   float64      `json:"profit_and_loss_ratio"`
+	Sources              []FundReport `json:"sources,omitempty"` // 複数の保有分を合算した場合の合算元
+}
+
+func newFundReport(f *Fund, weight float64) FundReport {
+	return FundReport{
+		Code:                 string(f.Code),
+		Name:                 f.Name,
+		Weight:               weight,
+		Amount:               f.Amount,
+		AcquisitionUnitPrice: f.AcquisitionUnitPrice,
+		CurrentUnitPrice:     f.CurrentUnitPrice,
+		AcquisitionPrice:     f.AcquisitionPrice,
+		CurrentPrice:         f.CurrentPrice,
+		ProfitAndLoss:        f.ProfitAndLoss(),
+		ProfitAndLossRatio:   finite(f.ProfitAndLossRatio()),
+	}
+}
+
+// BuyReport リバランス購入の結果
+type BuyReport struct {
+	Class  string  `json:"class"`
+	Name   string  `json:"name"`
+	Amount float64 `json:"amount"`
+}
+
+// finite JSONで表現できないNaNやInfを0にする
+func finite(f float64) float64 {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0
+	}
+	return f
+}
+
+// Report 機械可読な形式で出力するためのReportを作る
+func (a *AssetAllocation) Report() *Report {
+	r := &Report{
+		AcquisitionPrice:   a.AcquisitionPrice(),
+		CurrentPrice:       a.CurrentPrice(),
+		ProfitAndLoss:      a.ProfitAndLoss(),
+		ProfitAndLossRatio: finite(a.ProfitAndLossRatio()),
+		Classes:            []ClassReport{},
+	}
+
+	for _, d := range a.Details() {
+		cr := ClassReport{
+			Class:              d.Class().Key(),
+			Name:               d.Class().Definition().Name,
+			TargetRatio:        d.TargetRatio(),
+			CurrentRatio:       finite(d.CurrentRatio()),
+			TargetPrice:        d.TargetPrice(),
+			AcquisitionPrice:   d.AcquisitionPrice(),
+			CurrentPrice:       d.CurrentPrice(),
+			Diff:               d.Diff(),
+			ProfitAndLossRatio: finite(d.ProfitAndLossRatio()),
+			Funds:              []FundReport{},
+		}
+
+		for _, h := range d.Funds() {
+			fr := newFundReport(&h.Fund, h.Weight)
+			if len(h.Sources) > 1 {
+				for i := range h.Sources {
+					fr.Sources = append(fr.Sources, newFundReport(&h.Sources[i], h.Weight))
+				}
+			}
+			cr.Funds = append(cr.Funds, fr)
+		}
+
+		r.Classes = append(r.Classes, cr)
+	}
+
+	return r
+}
+
+// SetBuy RebalancingBuyの結果を追加する
+func (r *Report) SetBuy(buy map[AssetClass]float64) {
+	r.Buy = []BuyReport{}
+
+	for _, class := range AssetClasses() {
+		if v, e := buy[class]; e {
+			r.Buy = append(r.Buy, BuyReport{
+				Class:  class.Key(),
+				Name:   class.Definition().Name,
+				Amount: v,
+			})
+		}
+	}
+}
+
+// WriteJSON JSONで書き出す
+func WriteJSON(w io.Writer, v interface{}) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return errors.Wrap(e.Encode(v), "can't write json")
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// WriteCSV アセットクラスごとに1行のCSVで書き出す
+// 1行目は全体の合計で、class列はTotalになる
+func (r *Report) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+
+	header := []string{
+		"class",
+		"name",
+		"target_ratio",
+		"current_ratio",
+		"target_price",
+		"acquisition_price",
+		"current_price",
+		"diff",
+		"profit_and_loss_ratio",
+	}
+	if r.Buy != nil {
+		header = append(header, "buy")
+	}
+
+	buy := map[string]float64{}
+	for _, b := range r.Buy {
+		buy[b.Class] = b.Amount
+	}
+
+	rows := [][]string{header}
+
+	total := []string{"Total", "", "", "", "", formatFloat(r.AcquisitionPrice), formatFloat(r.CurrentPrice), "", formatFloat(r.ProfitAndLossRatio)}
+	if r.Buy != nil {
+		total = append(total, "")
+	}
+	rows = append(rows, total)
+
+	for _, c := range r.Classes {
+		row := []string{
+			c.Class,
+			c.Name,
+			formatFloat(c.TargetRatio),
+			formatFloat(c.CurrentRatio),
+			formatFloat(c.TargetPrice),
+			formatFloat(c.AcquisitionPrice),
+			formatFloat(c.CurrentPrice),
+			formatFloat(c.Diff),
+			formatFloat(c.ProfitAndLossRatio),
+		}
+		if r.Buy != nil {
+			row = append(row, formatFloat(buy[c.Class]))
+		}
+		rows = append(rows, row)
+	}
+
+	return errors.Wrap(cw.WriteAll(rows), "can't write csv")
+}
+
+// WriteFundsCSV ファンドごとに1行のCSVで書き出す
+// 資産構成を持つファンドは、割り当てたアセットクラスごとに1行になる
+func (r *Report) WriteFundsCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+
+	rows := [][]string{{
+		"class",
+		"code",
+		"name",
+		"weight",
+		"amount",
+		"acquisition_unit_price",
+		"current_unit_price",
+		"acquisition_price",
+		"current_price",
+		"profit_and_loss",
+		"profit_and_loss_ratio",
+	}}
+
+	for _, c := range r.Classes {
+		for _, f := range c.Funds {
+			rows = append(rows, []string{
+				c.Class,
+				f.Code,
+				f.Name,
+				formatFloat(f.Weight),
+				strconv.Itoa(f.Amount),
+				formatFloat(f.AcquisitionUnitPrice),
+				formatFloat(f.CurrentUnitPrice),
+				formatFloat(f.AcquisitionPrice),
+				formatFloat(f.CurrentPrice),
+				formatFloat(f.ProfitAndLoss),
+				formatFloat(f.ProfitAndLossRatio),
+			})
+		}
+	}
+
+	return errors.Wrap(cw.WriteAll(rows), "can't write csv")
+}
+
+// FundClassReport ファンドごとのSBIの商品分類によるアセットクラスと実際に使うアセットクラス
+type FundClassReport struct {
+	Code      string `json:"code"`
+	Name      string `json:"name"`
+	Scraped   string `json:"scraped"`
+	Effective string `json:"effective"`
+}
+
+// NewFundClassReports override listの出力を作る
+func NewFundClassReports(funds []*Fund, overrides ClassOverrides) []FundClassReport {
+	reports := []FundClassReport{}
+	seen := map[FundCode]bool{}
+
+	for _, f := range funds {
+		if seen[f.Code] {
+			continue
+		}
+		seen[f.Code] = true
+
+		reports = append(reports, FundClassReport{
+			Code:      string(f.Code),
+			Name:      f.Name,
+			Scraped:   f.AssetClass.Key(),
+			Effective: overrides.Class(f.Code, f.AssetClass).Key(),
+		})
+	}
+
+	return reports
+}
+
+// WriteFundClassReportsCSV override listの出力をCSVで書き出す
+func WriteFundClassReportsCSV(w io.Writer, reports []FundClassReport) error {
+	cw := csv.NewWriter(w)
+
+	rows := [][]string{{"code", "name", "scraped", "effective"}}
+	for _, r := range reports {
+		rows = append(rows, []string{r.Code, r.Name, r.Scraped, r.Effective})
+	}
+
+	return errors.Wrap(cw.WriteAll(rows), "can't write csv")
+}
+
+// CacheEntry キャッシュしているファンド情報
+type CacheEntry struct {
+	Code      string    `json:"code"`
+	Name      string    `json:"name"`
+	Class     string    `json:"class"`
+	Category  string    `json:"category,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Expired   bool      `json:"expired"`
+	Pinned    bool      `json:"pinned"`
+}
+
+// NewCacheEntries cache listの出力を作る
+func NewCacheEntries(c Cache) ([]CacheEntry, error) {
+	codes, err := c.FundCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []CacheEntry{}
+	for _, code := range codes {
+		fi, err := c.GetFund(code)
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't read cached fund %v", code)
+		}
+
+		updatedAt, err := c.FundUpdatedAt(code)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, CacheEntry{
+			Code:      string(code),
+			Name:      fi.Name,
+			Class:     fi.Class.Key(),
+			Category:  fi.Category,
+			UpdatedAt: updatedAt,
+			Expired:   c.IsFundExpired(code),
+			Pinned:    fi.Pinned,
+		})
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,99 @@
+package yajirobe
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"testing"
+)
+
+func testFunds() []*Fund {
+	funds := []*Fund{
+		newFund(EmergingStocks, 200),
+		newFund(DomesticStocks, 200),
+		newFund(InternationalStocks, 600),
+	}
+
+	for _, f := range funds {
+		f.AcquisitionPrice = f.CurrentPrice / 2
+	}
+
+	return funds
+}
+
+func testAllocation() AssetAllocation {
+	funds := testFunds()
+
+	target := AllocationTarget{
+		EmergingStocks:      0.25,
+		DomesticStocks:      0.30,
+		InternationalStocks: 0.45,
+	}
+
+	return NewAssetAllocation([]*Stock{}, funds, target)
+}
+
+func TestReportJSON(t *testing.T) {
+	a := testAllocation()
+	report := a.Report()
+	report.SetBuy(a.RebalancingBuy(100))
+
+	buf := &bytes.Buffer{}
+	if err := WriteJSON(buf, report); err != nil {
+		t.Fatal(err)
+	}
+
+	decoded := &Report{}
+	if err := json.Unmarshal(buf.Bytes(), decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.CurrentPrice != 1000 || decoded.AcquisitionPrice != 500 {
+		t.Errorf("unexpected totals: %+v", decoded)
+	}
+
+	if len(decoded.Classes) != 3 || decoded.Classes[0].Class != "DomesticStocks" {
+		t.Fatalf("unexpected classes: %+v", decoded.Classes)
+	}
+
+	ds := decoded.Classes[0]
+	if ds.TargetRatio != 0.30 || ds.CurrentRatio != 0.2 || ds.Diff != -100 || ds.ProfitAndLossRatio != 1 {
+		t.Errorf("unexpected class detail: %+v", ds)
+	}
+
+	if len(ds.Funds) != 1 || ds.Funds[0].Weight != 1 || ds.Funds[0].CurrentPrice != 200 {
+		t.Errorf("unexpected funds: %+v", ds.Funds)
+	}
+
+	if len(decoded.Buy) != 2 || decoded.Buy[0].Class != "DomesticStocks" || decoded.Buy[0].Amount != 63 {
+		t.Errorf("unexpected buy: %+v", decoded.Buy)
+	}
+}
+
+func TestReportCSV(t *testing.T) {
+	a := testAllocation()
+	report := a.Report()
+
+	buf := &bytes.Buffer{}
+	if err := report.WriteCSV(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := csv.NewReader(buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// header, Total, 3 classes
+	if len(rows) != 5 {
+		t.Fatalf("expected 5 rows but got %d", len(rows))
+	}
+
+	if rows[1][0] != "Total" || rows[1][6] != "1000" {
+		t.Errorf("unexpected total row: %v", rows[1])
+	}
+
+	if rows[2][0] != "DomesticStocks" || rows[2][2] != "0.3" {
+		t.Errorf("unexpected class row: %v", rows[2])
+	}
+}
@@ -1,45 +1,101 @@
 package yajirobe
 
 import (
+	"context"
 	"net/url"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/headzoo/surf/agent"
 	"github.com/headzoo/surf/browser"
 	"github.com/pkg/errors"
+	"go.uber.org/multierr"
 	"go.uber.org/zap"
 	"gopkg.in/headzoo/surf.v1"
 )
 
+// 標準のファンド情報の先読みの設定
+const (
+	defaultFundInfoWorkers  = 4
+	defaultFundInfoInterval = 500 * time.Millisecond
+)
+
 type sbiClient struct {
-	browser *browser.Browser
-	cache   Cache
-	Logger  *zap.SugaredLogger
+	browser    *browser.Browser
+	cache      Cache
+	classifier *Classifier
+	refresh    bool
+	Logger     *zap.SugaredLogger
+
+	// HTTPリクエスト
+	transport *sbiTransport
+	ctx       context.Context
+
+	// ファンド情報の先読み
+	workers    int
+	limiter    *rateLimiter
+	fetch      func(bow *browser.Browser, code FundCode) (*FundInfo, error)
+	mu         sync.Mutex
+	prefetched map[FundCode]*FundInfo
 }
 
 // SbiOption NewSbiScannerの引数
 type SbiOption struct {
-	UserID   string
-	Password string
-	Cache    Cache
-	Logger   *zap.Logger
+	UserID     string
+	Password   string
+	Cache      Cache
+	Classifier *Classifier // nilならDefaultClassifierを使う
+	Refresh    bool        // キャッシュがあってもファンド情報を取得し直す
+	Logger     *zap.Logger
+
+	// FundInfoWorkers ファンド情報を同時に取得する数 0なら4、負なら先にまとめて取得しない
+	FundInfoWorkers int
+	// FundInfoInterval ファンド情報を取得する間隔 0なら500ms、負なら間隔を空けない
+	FundInfoInterval time.Duration
+
+	// HTTP すべてのHTTPリクエストのタイムアウト、再試行、間隔
+	HTTP HTTPOption
 }
 
 // NewSbiScanner SBI証券用Scannerを作る
-func NewSbiScanner(option SbiOption) (Scanner, error) {
+// ctxが取り消されたらログインを途中でやめる
+func NewSbiScanner(ctx context.Context, option SbiOption) (Scanner, error) {
 	if option.Logger == nil {
 		option.Logger = zap.NewNop()
 	}
 
-	client := &sbiClient{
-		browser: surf.NewBrowser(),
-		cache:   option.Cache,
-		Logger:  option.Logger.Sugar(),
+	if option.Classifier == nil {
+		option.Classifier = DefaultClassifier()
+	}
+
+	if option.FundInfoWorkers == 0 {
+		option.FundInfoWorkers = defaultFundInfoWorkers
+	} else if option.FundInfoWorkers < 0 {
+		option.FundInfoWorkers = 0
+	}
+
+	if option.FundInfoInterval == 0 {
+		option.FundInfoInterval = defaultFundInfoInterval
 	}
 
+	client := &sbiClient{
+		cache:      option.Cache,
+		classifier: option.Classifier,
+		refresh:    option.Refresh,
+		Logger:     option.Logger.Sugar(),
+		transport:  newSbiTransport(option.HTTP, option.Logger.Sugar()),
+		workers:    option.FundInfoWorkers,
+		limiter:    newRateLimiter(option.FundInfoInterval),
+		prefetched: map[FundCode]*FundInfo{},
+	}
+	client.fetch = client.getFundInfo
+	client.browser = client.newBrowser()
+	client.setContext(ctx)
+
 	if err := client.login(option.UserID, option.Password); err != nil {
 		return nil, errors.Wrap(err, "can't login")
 	}
@@ -48,9 +104,33 @@ func NewSbiScanner(option SbiOption) (Scanner, error) {
 	return client, nil
 }
 
+// newBrowser SBIへのリクエストをc.transportに送るブラウザを作る
+func (c *sbiClient) newBrowser() *browser.Browser {
+	bow := surf.NewBrowser()
+	bow.SetUserAgent(agent.Chrome())
+	if c.transport != nil {
+		bow.SetTransport(newSurfTransport(c.transport))
+	}
+	return bow
+}
+
+// setContext これからのリクエストをctxで取り消せるようにする
+func (c *sbiClient) setContext(ctx context.Context) {
+	c.ctx = ctx
+	if c.transport != nil {
+		c.transport.setContext(ctx)
+	}
+}
+
+func (c *sbiClient) context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
 func (c *sbiClient) login(userID, password string) error {
 	bow := c.browser
-	bow.SetUserAgent(agent.Chrome())
 
 	if err := bow.Open("https://www.sbisec.co.jp/ETGate"); err != nil {
 		return errors.Wrap(err, "SBI: Can't open sbi top page")
@@ -163,8 +243,9 @@ func (c *sbiClient) scanStock(row *goquery.Selection) *Stock {
 	}
 }
 
-func (c *sbiClient) getFundInfo(code FundCode) (*FundInfo, error) {
-	bow := c.browser
+// getFundInfo bowでファンド詳細ページを開いてファンド情報を得る
+// ファンド詳細ページはログインしていなくても見られる
+func (c *sbiClient) getFundInfo(bow *browser.Browser, code FundCode) (*FundInfo, error) {
 	url, _ := url.Parse("https://site0.sbisec.co.jp/marble/fund/detail/achievement.do")
 	query := url.Query()
 	query.Set("Param6", string(code))
@@ -180,40 +261,165 @@ func (c *sbiClient) getFundInfo(code FundCode) (*FundInfo, error) {
 	if len(names) > 1 {
 		name = names[1]
 	}
-	assetClass := parseAssetClass(strings.TrimSpace(toUtf8(category.Text())))
+	categoryText := strings.TrimSpace(toUtf8(category.Text()))
+	assetClass := c.classifier.Classify(categoryText, name)
 
 	return &FundInfo{
-		Name:  name,
-		Class: assetClass,
-		Code:  code,
+		Name:     name,
+		Class:    assetClass,
+		Code:     code,
+		Category: categoryText,
 	}, nil
 }
 
+// pinnedFund Pinnedなファンド情報がキャッシュにあれば返す
+func (c *sbiClient) pinnedFund(code FundCode) (*FundInfo, bool) {
+	fi, err := c.cache.GetFund(code)
+	if err != nil || !fi.Pinned {
+		return nil, false
+	}
+	return fi, true
+}
+
+// fundInfo キャッシュまたはSBIのファンド詳細ページからファンド情報を得る
+// 商品分類がキャッシュされていれば現在の分類ルールで分類し直す
+// Pinnedなファンド情報はrefreshでも取得し直さず、分類もそのまま使う
+func (c *sbiClient) fundInfo(code FundCode) (*FundInfo, error) {
+	c.mu.Lock()
+	fi, e := c.prefetched[code]
+	c.mu.Unlock()
+	if e {
+		return fi, nil
+	}
+
+	if fi, ok := c.pinnedFund(code); ok {
+		return fi, nil
+	}
+
+	if !c.refresh && c.cache.CanGetFund(code) {
+		fi, err := c.cache.GetFund(code)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if fi.Category != "" {
+			fi.Class = c.classifier.Classify(fi.Category, fi.Name)
+		}
+		return fi, nil
+	}
+
+	if err := c.limiter.Wait(c.context()); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	fi, err := c.fetch(c.browser, code)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err = c.cache.SetFund(fi); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return fi, nil
+}
+
+// prefetchFundInfo キャッシュにないファンド情報をまとめて取得する
+// ワーカーごとに別のブラウザを使って同時に取得し、取得する間隔はlimiterで空ける
+// ワーカーがなければ何もせず、getFundInfoで1つずつ取得する
+func (c *sbiClient) prefetchFundInfo(codes []FundCode) error {
+	if c.workers <= 0 {
+		return nil
+	}
+
+	missing := []FundCode{}
+	seen := map[FundCode]bool{}
+
+	c.mu.Lock()
+	for _, code := range codes {
+		if _, e := c.prefetched[code]; e || seen[code] || code == "" {
+			continue
+		}
+		seen[code] = true
+		if _, pinned := c.pinnedFund(code); pinned {
+			continue
+		}
+		if c.refresh || !c.cache.CanGetFund(code) {
+			missing = append(missing, code)
+		}
+	}
+	c.mu.Unlock()
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	workers := c.workers
+	if workers > len(missing) {
+		workers = len(missing)
+	}
+	c.Logger.Debugf("sbi: prefetch %d funds with %d workers", len(missing), workers)
+
+	queue := make(chan FundCode)
+	wg := sync.WaitGroup{}
+	errs := []error{}
+	fetched := []*FundInfo{}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			bow := c.newBrowser()
+
+			for code := range queue {
+				err := c.limiter.Wait(c.context())
+				var fi *FundInfo
+				if err == nil {
+					fi, err = c.fetch(bow, code)
+				}
+
+				c.mu.Lock()
+				if err != nil {
+					errs = append(errs, errors.Wrapf(err, "can't prefetch fund %v", code))
+				} else {
+					fetched = append(fetched, fi)
+					c.prefetched[code] = fi
+				}
+				c.mu.Unlock()
+			}
+		}()
+	}
+
+	for _, code := range missing {
+		queue <- code
+	}
+	close(queue)
+	wg.Wait()
+
+	// 取得できたものは失敗したものがあっても保存する
+	if err := c.cache.SetFunds(fetched); err != nil {
+		errs = append(errs, errors.WithStack(err))
+	}
+
+	return multierr.Combine(errs...)
+}
+
+// fundCodeFromLink ファンド名のリンクからファンドコードを得る
+func fundCodeFromLink(a *goquery.Selection) FundCode {
+	url, _ := url.Parse(a.AttrOr("href", "Fund name not found"))
+	return FundCode(url.Query().Get("fund_sec_code"))
+}
+
 func (c *sbiClient) scanFund(row *goquery.Selection) (*Fund, error) {
 	cells := iterate(row.Find("td"))
 
-	href := cells[0].Find("a").AttrOr("href", "Fund name not found")
-	url, _ := url.Parse(href)
-	query := url.Query()
-	code := FundCode(query.Get("fund_sec_code"))
+	code := fundCodeFromLink(cells[0].Find("a"))
 	amount := parseSeparatedInt(cells[1].Text())
 	units := iterateText(cells[2])
 	acquisitionUnitPrice := parseSeparatedInt(units[0])
 	currentUnitPrice := parseSeparatedInt(units[1])
 
-	var fi *FundInfo
-	var err error
-	if c.cache.CanGetFund(code) {
-		if fi, err = c.cache.GetFund(code); err != nil {
-			return nil, errors.WithStack(err)
-		}
-	} else {
-		if fi, err = c.getFundInfo(code); err != nil {
-			return nil, errors.WithStack(err)
-		}
-		if err = c.cache.SetFund(fi); err != nil {
-			return nil, errors.WithStack(err)
-		}
+	fi, err := c.fundInfo(code)
+	if err != nil {
+		return nil, err
 	}
 
 	name := fi.Name
@@ -223,6 +429,7 @@ func (c *sbiClient) scanFund(row *goquery.Selection) (*Fund, error) {
 		Name:                 name,
 		Code:                 code,
 		AssetClass:           class,
+		Composition:          fi.Composition,
 		Amount:               int(amount),
 		AcquisitionUnitPrice: float64(acquisitionUnitPrice),
 		CurrentUnitPrice:     float64(currentUnitPrice),
@@ -252,21 +459,33 @@ func (c *sbiClient) fundsFromAccountPage() ([]*Fund, error) {
 	fundFont := bow.Find(toSjis("font:contains('ファンド名')"))
 	fundTables := iterate(fundFont.Parent().Parent().Parent().Parent())
 
-	funds := []*Fund{}
-
+	rows := []*goquery.Selection{}
 	for _, table := range fundTables {
 		for i, tr := range iterate(table.Find("tr")) {
-			if i%2 == 0 {
-				continue
-			}
-			f, e := c.scanFund(tr)
-			if e != nil {
-				return nil, errors.Wrap(e, "can't read fund table")
+			if i%2 == 1 {
+				rows = append(rows, tr)
 			}
-			funds = append(funds, f)
 		}
 	}
 
+	// 先にキャッシュにないファンド情報をまとめて取得しておく
+	codes := []FundCode{}
+	for _, tr := range rows {
+		codes = append(codes, fundCodeFromLink(tr.Find("td").First().Find("a")))
+	}
+	if err := c.prefetchFundInfo(codes); err != nil {
+		return nil, err
+	}
+
+	funds := []*Fund{}
+	for _, tr := range rows {
+		f, e := c.scanFund(tr)
+		if e != nil {
+			return nil, errors.Wrap(e, "can't read fund table")
+		}
+		funds = append(funds, f)
+	}
+
 	return funds, nil
 }
 
@@ -319,25 +538,11 @@ func (c *sbiClient) scanFundOrdered(tr []*goquery.Selection) (*Fund, error) {
 		return nil, errors.Errorf("unexpected table structure of the ordered funds. expected cells [4, 5] but got [%d, %d]", len(r0), len(r1))
 	}
 
-	fnameA := r0[2].Find("a") // ファンド名aタグ
-	href := fnameA.AttrOr("href", "invalid url")
-	url, _ := url.Parse(href)
-	query := url.Query()
-	code := FundCode(query.Get("fund_sec_code"))
+	code := fundCodeFromLink(r0[2].Find("a")) // ファンド名aタグ
 
-	var fi *FundInfo
-	var err error
-	if c.cache.CanGetFund(code) {
-		if fi, err = c.cache.GetFund(code); err != nil {
-			return nil, errors.WithStack(err)
-		}
-	} else {
-		if fi, err = c.getFundInfo(code); err != nil {
-			return nil, errors.WithStack(err)
-		}
-		if err = c.cache.SetFund(fi); err != nil {
-			return nil, errors.WithStack(err)
-		}
+	fi, err := c.fundInfo(code)
+	if err != nil {
+		return nil, err
 	}
 
 	orderAmountText := toUtf8(iterateText(r1[2])[0])
@@ -356,6 +561,7 @@ func (c *sbiClient) scanFundOrdered(tr []*goquery.Selection) (*Fund, error) {
 		Name:             name,
 		Code:             code,
 		AssetClass:       class,
+		Composition:      fi.Composition,
 		AcquisitionPrice: float64(orderAmount),
 		CurrentPrice:     float64(orderAmount),
 	}, nil
@@ -371,6 +577,16 @@ func (c *sbiClient) fundsFromInvestmentTrustOrderPage() ([]*Fund, error) {
 	// 注文中のファンドは
 	// コード・名称・資産クラス・現在価格のみを設定する
 	c.Logger.Debugf("order table length: %d", len(rows))
+
+	// 先にキャッシュにないファンド情報をまとめて取得しておく
+	codes := []FundCode{}
+	for i := 0; i < len(rows)/2; i++ {
+		codes = append(codes, fundCodeFromLink(rows[i*2].Find("td").Eq(2).Find("a")))
+	}
+	if err := c.prefetchFundInfo(codes); err != nil {
+		return nil, err
+	}
+
 	for i := 0; i < len(rows)/2; i++ {
 		f, e := c.scanFundOrdered(rows[i*2 : i*2+2])
 		if e != nil {
@@ -468,7 +684,9 @@ func (c *sbiClient) fundsFromForeignAccountPage() ([]*Fund, error) {
 	return funds, nil
 }
 
-func (c *sbiClient) Scan() ([]*Stock, []*Fund, error) {
+func (c *sbiClient) Scan(ctx context.Context) ([]*Stock, []*Fund, error) {
+	c.setContext(ctx)
+
 	if e := c.accountPage(); e != nil {
 		return nil, nil, e
 	}
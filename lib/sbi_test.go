@@ -2,10 +2,16 @@ package yajirobe
 
 import (
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/headzoo/surf"
+	"github.com/headzoo/surf/browser"
+	"github.com/masaedw/yajirobe/lib/storedmap"
+	"github.com/pkg/errors"
+	"go.uber.org/multierr"
 	"go.uber.org/zap"
 	"golang.org/x/net/html"
 )
@@ -96,3 +102,137 @@ func TestParseForeignStock(t *testing.T) {
 		t.Errorf("CurrentPrice: expected %d but got %v", 108497, f.CurrentPrice)
 	}
 }
+
+func TestPrefetchFundInfo(t *testing.T) {
+	cache := NewMemoryCache()
+	cache.SetFund(&FundInfo{Code: "cached", Name: "Cached"})
+
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	fetched := map[FundCode]int{}
+
+	client := &sbiClient{
+		cache:      cache,
+		Logger:     zap.NewNop().Sugar(),
+		workers:    3,
+		limiter:    newRateLimiter(time.Millisecond),
+		prefetched: map[FundCode]*FundInfo{},
+	}
+	client.fetch = func(bow *browser.Browser, code FundCode) (*FundInfo, error) {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		fetched[code]++
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return &FundInfo{Code: code, Name: "Fund " + string(code)}, nil
+	}
+
+	codes := []FundCode{"cached", "1", "2", "3", "4", "5", "6", "1"}
+	if err := client.prefetchFundInfo(codes); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fetched) != 6 || fetched["cached"] != 0 || fetched["1"] != 1 {
+		t.Errorf("expected each missing fund to be fetched once but got %v", fetched)
+	}
+	if maxRunning < 2 || maxRunning > 3 {
+		t.Errorf("expected 2 or 3 concurrent fetches but got %d", maxRunning)
+	}
+
+	// 先読みしたファンド情報はキャッシュに保存され、fundInfoで使われる
+	if !cache.CanGetFund("6") {
+		t.Error("expected prefetched fund to be cached")
+	}
+	fi, err := client.fundInfo("6")
+	if err != nil || fi.Name != "Fund 6" || fetched["6"] != 1 {
+		t.Errorf("expected prefetched fund but got %+v, %v", fi, err)
+	}
+}
+
+func TestPrefetchFundInfoError(t *testing.T) {
+	client := &sbiClient{
+		cache:      NewMemoryCache(),
+		Logger:     zap.NewNop().Sugar(),
+		workers:    2,
+		prefetched: map[FundCode]*FundInfo{},
+	}
+	client.fetch = func(bow *browser.Browser, code FundCode) (*FundInfo, error) {
+		if code == "2" || code == "4" {
+			return nil, errors.New("page not found")
+		}
+		return &FundInfo{Code: code}, nil
+	}
+
+	if err := client.prefetchFundInfo([]FundCode{"1", "2", "3", "4"}); err == nil {
+		t.Fatal("expected an error")
+	} else if errs := multierr.Errors(err); len(errs) != 2 {
+		t.Errorf("expected an error for each failed fund but got %v", errs)
+	}
+
+	// 失敗したファンドがあっても取得できたものは保存する
+	if !client.cache.CanGetFund("1") || !client.cache.CanGetFund("3") {
+		t.Error("expected fetched funds to be cached")
+	}
+}
+
+func TestPrefetchFundInfoDisabled(t *testing.T) {
+	client := &sbiClient{
+		cache:      NewMemoryCache(),
+		Logger:     zap.NewNop().Sugar(),
+		prefetched: map[FundCode]*FundInfo{},
+	}
+	client.fetch = func(bow *browser.Browser, code FundCode) (*FundInfo, error) {
+		t.Errorf("unexpected fetch of %v", code)
+		return &FundInfo{Code: code}, nil
+	}
+
+	if err := client.prefetchFundInfo([]FundCode{"1", "2"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFundInfoPinned(t *testing.T) {
+	cache := NewCache(storedmap.NewMemoryMap(), time.Hour)
+	cache.SetFund(&FundInfo{
+		Code:      "1",
+		Name:      "Pinned",
+		Class:     InternationalBonds,
+		Category:  "国内株式",
+		UpdatedAt: time.Now().Add(-2 * time.Hour),
+		Pinned:    true,
+	})
+
+	client := &sbiClient{
+		cache:      cache,
+		Logger:     zap.NewNop().Sugar(),
+		workers:    1,
+		refresh:    true,
+		classifier: DefaultClassifier(),
+		prefetched: map[FundCode]*FundInfo{},
+	}
+	client.fetch = func(bow *browser.Browser, code FundCode) (*FundInfo, error) {
+		t.Errorf("expected pinned fund %v not to be fetched", code)
+		return &FundInfo{Code: code}, nil
+	}
+
+	if err := client.prefetchFundInfo([]FundCode{"1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 期限切れでもrefreshでも取得し直さず、商品分類から分類し直さない
+	fi, err := client.fundInfo("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Name != "Pinned" || fi.Class != InternationalBonds {
+		t.Errorf("expected pinned fund info but got %+v", fi)
+	}
+}
@@ -1,6 +1,9 @@
 package yajirobe
 
+import "context"
+
 // Scanner Scanner
 type Scanner interface {
-	Scan() ([]*Stock, []*Fund, error)
+	// Scan 保有銘柄とファンドを取得する ctxが取り消されたら途中でやめる
+	Scan(ctx context.Context) ([]*Stock, []*Fund, error)
 }
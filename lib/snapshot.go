@@ -0,0 +1,45 @@
+package yajirobe
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Snapshot 最後にスキャンした保有銘柄とファンド
+type Snapshot struct {
+	ScannedAt time.Time `json:"scanned_at"`
+	Stocks    []*Stock  `json:"stocks"`
+	Funds     []*Fund   `json:"funds"`
+}
+
+// snapshotKey スキャン結果を保存するキャッシュのキー
+const snapshotKey = "snapshot"
+
+// SaveSnapshot スキャン結果をキャッシュに保存する
+// 保有数量や価格を含むので、キャッシュを暗号化していれば一緒に暗号化する
+func SaveSnapshot(c Cache, s *Snapshot) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return errors.Wrap(err, "can't marshal snapshot")
+	}
+
+	return errors.Wrap(c.SetString(snapshotKey, string(data)), "can't save snapshot")
+}
+
+// LoadSnapshot 最後に保存したスキャン結果を読む
+// 保存していなければstoredmap.IsNotExistsがtrueになるエラーを返す
+func LoadSnapshot(c Cache) (*Snapshot, error) {
+	data, err := c.GetString(snapshotKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't load snapshot")
+	}
+
+	s := &Snapshot{}
+	if err := json.Unmarshal([]byte(data), s); err != nil {
+		return nil, errors.Wrap(err, "can't unmarshal snapshot")
+	}
+
+	return s, nil
+}
@@ -0,0 +1,41 @@
+package yajirobe
+
+import (
+	"testing"
+	"time"
+
+	"github.com/masaedw/yajirobe/lib/storedmap"
+)
+
+func TestSnapshot(t *testing.T) {
+	c := NewMemoryCache()
+
+	if _, err := LoadSnapshot(c); !storedmap.IsNotExists(err) {
+		t.Fatalf("expected not exist error but got %v", err)
+	}
+
+	f := newFund(DomesticStocks, 100)
+	f.Composition = Composition{DomesticStocks: 0.5, DomesticBonds: 0.5}
+	scannedAt := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	err := SaveSnapshot(c, &Snapshot{
+		ScannedAt: scannedAt,
+		Stocks:    []*Stock{{Name: "TestStock", Code: 1680, Amount: 10}},
+		Funds:     []*Fund{f},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := LoadSnapshot(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !s.ScannedAt.Equal(scannedAt) || len(s.Stocks) != 1 || s.Stocks[0].Code != 1680 {
+		t.Errorf("unexpected snapshot: %+v", s)
+	}
+	if len(s.Funds) != 1 || s.Funds[0].AssetClass != DomesticStocks || s.Funds[0].Composition[DomesticBonds] != 0.5 {
+		t.Errorf("unexpected funds: %+v", s.Funds[0])
+	}
+}
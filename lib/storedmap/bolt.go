@@ -0,0 +1,389 @@
+package storedmap
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+	"go.uber.org/zap"
+)
+
+// boltOpenTimeout 他のプロセスがデータベースを使っているときに待つ時間
+const boltOpenTimeout = 10 * time.Second
+
+// noNamespace "."を含まないキーを入れるバケット
+// 名前空間は最初の"."より前なので"."という名前空間はない
+const noNamespace = "."
+
+// boltMap ひとつのbboltのデータベースファイルに保存する
+// キーの最初の"."より前を名前空間として、名前空間ごとにバケットを分ける
+// キーにはどんな文字を使ってもよい
+//
+// 他のプロセスと同時に使えるように、操作のたびにデータベースを開いて閉じる
+type boltMap struct {
+	path   string
+	logger *zap.Logger
+}
+
+// splitKey キーをバケット名とバケット内のキーに分ける
+func splitKey(key string) ([]byte, []byte, error) {
+	if key == "" {
+		return nil, nil, errors.New("key is empty")
+	}
+
+	i := strings.Index(key, ".")
+	if i < 0 {
+		return []byte(noNamespace), []byte(key), nil
+	}
+	if i == 0 || i == len(key)-1 {
+		// 空の名前空間や名前空間だけのキーは"."のバケットに入れる
+		return []byte(noNamespace), []byte(key), nil
+	}
+
+	return []byte(key[:i]), []byte(key[i+1:]), nil
+}
+
+func joinKey(bucket, key []byte) string {
+	if string(bucket) == noNamespace {
+		return string(key)
+	}
+	return string(bucket) + "." + string(key)
+}
+
+// encodeValue 値の先頭に更新時刻を付ける
+func encodeValue(data []byte, modTime time.Time) []byte {
+	v := make([]byte, 8+len(data))
+	binary.BigEndian.PutUint64(v, uint64(modTime.UnixNano()))
+	copy(v[8:], data)
+	return v
+}
+
+func decodeValue(v []byte) ([]byte, time.Time, error) {
+	if len(v) < 8 {
+		return nil, time.Time{}, errors.New("broken value in the database")
+	}
+
+	data := make([]byte, len(v)-8)
+	copy(data, v[8:])
+	return data, time.Unix(0, int64(binary.BigEndian.Uint64(v))), nil
+}
+
+// view データベースを読み込み専用で開いてfnを呼ぶ
+// データベースがまだなければfnを呼ばずにfalseを返す
+func (c *boltMap) view(fn func(tx *bolt.Tx) error) (bool, error) {
+	if _, err := os.Stat(c.path); os.IsNotExist(err) {
+		return false, nil
+	}
+
+	db, err := bolt.Open(c.path, 0600, &bolt.Options{Timeout: boltOpenTimeout, ReadOnly: true})
+	if err != nil {
+		return false, errors.Wrap(err, "can't open database")
+	}
+	defer db.Close()
+
+	return true, db.View(fn)
+}
+
+// update データベースを開いてトランザクションの中でfnを呼ぶ
+func (c *boltMap) update(fn func(tx *bolt.Tx) error) error {
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return errors.Wrap(err, "can't prepare directory")
+	}
+
+	db, err := bolt.Open(c.path, 0600, &bolt.Options{Timeout: boltOpenTimeout})
+	if err != nil {
+		return errors.Wrap(err, "can't open database")
+	}
+
+	if err := db.Update(fn); err != nil {
+		db.Close()
+		return err
+	}
+
+	return errors.Wrap(db.Close(), "can't close database")
+}
+
+// entry keyの値と更新時刻を読む
+func (c *boltMap) entry(key string) ([]byte, time.Time, error) {
+	bucket, k, err := splitKey(key)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	var v []byte
+	_, err = c.view(func(tx *bolt.Tx) error {
+		if b := tx.Bucket(bucket); b != nil {
+			if found := b.Get(k); found != nil {
+				v = append([]byte{}, found...)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	if v == nil {
+		c.logger.Sugar().Debugf("cache miss: %v", key)
+		return nil, time.Time{}, &NotExistsError{Key: key}
+	}
+
+	c.logger.Sugar().Debugf("cache hit: %v", key)
+	return decodeValue(v)
+}
+
+func (c *boltMap) Get(key string) ([]byte, error) {
+	data, _, err := c.entry(key)
+	return data, err
+}
+
+func (c *boltMap) CanGet(key string) bool {
+	_, err := c.Get(key)
+	return err == nil
+}
+
+func (c *boltMap) Set(key string, data []byte) error {
+	batch := &Batch{}
+	batch.Set(key, data)
+	return c.WriteBatch(batch)
+}
+
+func (c *boltMap) Keys(prefix string) ([]string, error) {
+	keys := []string{}
+
+	_, err := c.view(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			return b.ForEach(func(k, _ []byte) error {
+				if key := joinKey(name, k); strings.HasPrefix(key, prefix) {
+					keys = append(keys, key)
+				}
+				return nil
+			})
+		})
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "can't list keys")
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (c *boltMap) Delete(key string) error {
+	bucket, k, err := splitKey(key)
+	if err != nil {
+		return err
+	}
+
+	return c.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+		if b == nil || b.Get(k) == nil {
+			return &NotExistsError{Key: key}
+		}
+		return b.Delete(k)
+	})
+}
+
+// WriteBatch ひとつのトランザクションで書き込む
+func (c *boltMap) WriteBatch(batch *Batch) error {
+	now := time.Now()
+	return c.update(func(tx *bolt.Tx) error {
+		for _, op := range batch.ops {
+			modTime := now
+			if !op.modTime.IsZero() {
+				modTime = op.modTime
+			}
+			if err := putOp(tx, op, modTime); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func putOp(tx *bolt.Tx, op batchOp, modTime time.Time) error {
+	bucket, k, err := splitKey(op.key)
+	if err != nil {
+		return err
+	}
+
+	if op.delete {
+		if b := tx.Bucket(bucket); b != nil {
+			return errors.Wrap(b.Delete(k), "can't delete from bucket")
+		}
+		return nil
+	}
+
+	b, err := tx.CreateBucketIfNotExists(bucket)
+	if err != nil {
+		return errors.Wrap(err, "can't create bucket")
+	}
+
+	return errors.Wrap(b.Put(k, encodeValue(op.data, modTime)), "can't put to bucket")
+}
+
+// migrateFrom 以前のfileMapのファイルを更新時刻ごとデータベースに移す
+// データベースに書き込めたら移したファイルを消す
+func (c *boltMap) migrateFrom(old *fileMap) (int, error) {
+	keys, err := old.Keys("")
+	if err != nil {
+		return 0, err
+	}
+
+	batch := &Batch{}
+	migrated := &Batch{}
+	for _, key := range keys {
+		data, err := old.Get(key)
+		if err != nil {
+			return 0, err
+		}
+		modTime, err := old.ModTime(key)
+		if err != nil {
+			return 0, err
+		}
+		batch.ops = append(batch.ops, batchOp{key: key, data: data, modTime: modTime})
+		migrated.Delete(key)
+	}
+
+	if err := c.WriteBatch(batch); err != nil {
+		return 0, errors.Wrap(err, "can't migrate cache files")
+	}
+
+	if err := old.WriteBatch(migrated); err != nil {
+		return 0, errors.Wrap(err, "can't remove migrated cache files")
+	}
+
+	return batch.Len(), nil
+}
+
+// compact 消したエントリのデータが空きページに残らないように、データベースファイルを書き直す
+// 以前のfileMapのファイルが残っていれば消す
+// 書き直している間に他のプロセスが書き込んだ変更は失われる
+func (c *boltMap) compact() error {
+	old := &fileMap{path: filepath.Dir(c.path), logger: c.logger}
+	keys, err := old.Keys("")
+	if err != nil {
+		return err
+	}
+	legacy := &Batch{}
+	for _, key := range keys {
+		legacy.Delete(key)
+	}
+	if err := old.WriteBatch(legacy); err != nil {
+		return errors.Wrap(err, "can't remove legacy cache files")
+	}
+
+	if _, err := os.Stat(c.path); os.IsNotExist(err) {
+		return nil
+	}
+
+	src, err := bolt.Open(c.path, 0600, &bolt.Options{Timeout: boltOpenTimeout})
+	if err != nil {
+		return errors.Wrap(err, "can't open database")
+	}
+	defer src.Close()
+
+	temp, err := ioutil.TempFile(filepath.Dir(c.path), "cache.db.tmp-")
+	if err != nil {
+		return errors.Wrap(err, "can't create temporary file")
+	}
+	temp.Close()
+
+	if err := copyDB(temp.Name(), src); err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+
+	if err := src.Close(); err != nil {
+		os.Remove(temp.Name())
+		return errors.Wrap(err, "can't close database")
+	}
+
+	if err := os.Rename(temp.Name(), c.path); err != nil {
+		os.Remove(temp.Name())
+		return errors.Wrap(err, "can't replace database")
+	}
+
+	return nil
+}
+
+// Compact 削除や暗号化し直す前のエントリのデータがファイルに残らないようにする
+// boltMapはデータベースファイルを書き直し、以前のfileMapのファイルも消す
+// fileMapはエントリを消すとファイルも消えるので何もしない
+func Compact(smap StoredMap) error {
+	if c, ok := smap.(*boltMap); ok {
+		return errors.Wrap(c.compact(), "can't compact cache")
+	}
+	return nil
+}
+
+// copyDB srcのすべてのバケットをpathの新しいデータベースに写す
+func copyDB(path string, src *bolt.DB) error {
+	dst, err := bolt.Open(path, 0600, &bolt.Options{Timeout: boltOpenTimeout})
+	if err != nil {
+		return errors.Wrap(err, "can't open temporary database")
+	}
+
+	err = dst.Update(func(dtx *bolt.Tx) error {
+		return src.View(func(stx *bolt.Tx) error {
+			return stx.ForEach(func(name []byte, sb *bolt.Bucket) error {
+				db, err := dtx.CreateBucket(name)
+				if err != nil {
+					return errors.Wrap(err, "can't create bucket")
+				}
+				return sb.ForEach(func(k, v []byte) error {
+					return errors.Wrap(db.Put(append([]byte{}, k...), append([]byte{}, v...)), "can't put to bucket")
+				})
+			})
+		})
+	})
+	if err != nil {
+		dst.Close()
+		return err
+	}
+
+	return errors.Wrap(dst.Close(), "can't close temporary database")
+}
+
+func newBoltMap(dir string, logger *zap.Logger) (*boltMap, error) {
+	c := &boltMap{
+		path:   filepath.Join(dir, "cache.db"),
+		logger: logger,
+	}
+
+	// データベースがまだなければ以前のキャッシュファイルを移す
+	if _, err := os.Stat(c.path); os.IsNotExist(err) {
+		old := &fileMap{path: dir, logger: logger}
+		n, err := c.migrateFrom(old)
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			logger.Sugar().Infof("migrated %d cache files to %s", n, c.path)
+		}
+	}
+
+	return c, nil
+}
+
+// NewBoltMap creates a StoredMap backed by a single bbolt database file.
+// If the database doesn't exist yet, NewBoltMap migrates the files of NewFileMap into it and removes them.
+// If logger is nil, NewBoltMap uses NewNop as logger.
+func NewBoltMap(logger *zap.Logger) (StoredMap, error) {
+	dirPath, err := CacheDir()
+	if err != nil {
+		return nil, err
+	}
+
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	return newBoltMap(dirPath, logger)
+}
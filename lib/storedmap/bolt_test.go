@@ -0,0 +1,152 @@
+package storedmap
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func tempBoltMap(t *testing.T, tempDir string) StoredMap {
+	c, err := newBoltMap(tempDir, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestBoltGetSet(t *testing.T) {
+	tempDir := tempDir(t)
+	defer os.RemoveAll(tempDir)
+
+	c := tempBoltMap(t, tempDir)
+
+	if _, err := c.Get("fund.12345"); !IsNotExists(err) {
+		t.Fatalf("expected NotExistsError but got %v", err)
+	}
+
+	// fileMapでは同じファイルになってしまうキーも区別する
+	keys := []string{"fund.12345", "fund.123-45", "fund.", ".fund", "snapshot", "日本語.キー"}
+	for _, k := range keys {
+		if err := c.Set(k, []byte(k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, k := range keys {
+		d, err := c.Get(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(d) != k {
+			t.Errorf("expected %s but got %s", k, d)
+		}
+	}
+
+	all, err := c.Keys("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != len(keys) {
+		t.Errorf("unexpected keys: %v", all)
+	}
+
+	if err := c.Set("", []byte("data")); err == nil {
+		t.Error("expected an error for the empty key")
+	}
+}
+
+func TestBoltKeysDelete(t *testing.T) {
+	tempDir := tempDir(t)
+	defer os.RemoveAll(tempDir)
+
+	testKeysDelete(t, tempBoltMap(t, tempDir))
+}
+
+func TestBoltWriteBatch(t *testing.T) {
+	tempDir := tempDir(t)
+	defer os.RemoveAll(tempDir)
+
+	testWriteBatch(t, tempBoltMap(t, tempDir))
+}
+
+func TestBoltMigrate(t *testing.T) {
+	tempDir := tempDir(t)
+	defer os.RemoveAll(tempDir)
+
+	old := tempDirInfoCache(tempDir)
+	old.Set("fund.12345", []byte("fund"))
+	old.Set("string.snapshot", []byte("snapshot"))
+
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(filepath.Join(tempDir, "cache", "fund.12345"), modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	c := tempBoltMap(t, tempDir)
+
+	if d, err := c.Get("fund.12345"); err != nil || string(d) != "fund" {
+		t.Fatalf("expected migrated data but got %s, %v", d, err)
+	}
+	if d, err := c.Get("string.snapshot"); err != nil || string(d) != "snapshot" {
+		t.Fatalf("expected migrated data but got %s, %v", d, err)
+	}
+	if _, m, _ := c.(*boltMap).entry("fund.12345"); !m.Equal(modTime) {
+		t.Errorf("expected modified time %v but got %v", modTime, m)
+	}
+
+	// 移したファイルは消す
+	if keys, _ := old.Keys(""); len(keys) != 0 {
+		t.Errorf("expected migrated files to be removed but got %v", keys)
+	}
+
+	// 一度移したら以前のファイルは読まない
+	old.Set("fund.67890", []byte("fund"))
+	if tempBoltMap(t, tempDir).CanGet("fund.67890") {
+		t.Error("expected migration to run only once")
+	}
+}
+
+func TestBoltCompactAfterReencrypt(t *testing.T) {
+	tempDir := tempDir(t)
+	defer os.RemoveAll(tempDir)
+
+	c := tempBoltMap(t, tempDir)
+	c.Set("fund.0331418A", []byte(`{"name":"SecretFund"}`))
+
+	// 移す前にデータベースがあって残っていた以前のファイル
+	old := tempDirInfoCache(tempDir)
+	old.Set("fund.12345", []byte(`{"name":"SecretFund"}`))
+
+	if _, err := Reencrypt(c, nil, testDataKey(t)); err != nil {
+		t.Fatal(err)
+	}
+	if err := Compact(c); err != nil {
+		t.Fatal(err)
+	}
+
+	err := filepath.Walk(tempDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if bytes.Contains(data, []byte("SecretFund")) {
+			t.Errorf("plain text is left in %s", path)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if keys, err := c.Keys(""); err != nil || len(keys) != 2 {
+		t.Errorf("expected the encrypted entry and the marker but got %v, %v", keys, err)
+	}
+}
@@ -0,0 +1,465 @@
+package storedmap
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/scrypt"
+)
+
+// encryptedKeyPrefix 暗号化したエントリのキーの先頭
+const encryptedKeyPrefix = "enc."
+
+// encryptedMarkerKey 暗号化したStoredMapであることを示すエントリのキー
+// 値はデータ鍵で暗号化してあり、鍵が合っているかを確かめるのに使う
+const encryptedMarkerKey = "meta.encrypted"
+
+// dataKeySize データ鍵の長さ 前半をAES-256、後半をキーのHMACに使う
+const dataKeySize = 64
+
+// keyFileVersion 鍵ファイルの形式のバージョン
+const keyFileVersion = 1
+
+// scryptのパラメータ
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// encryptedMap 別のStoredMapに値をAES-GCMで暗号化して保存する
+// キーからも保有しているファンドがわかるので、キーはHMACに置き換えて元のキーは値と一緒に暗号化する
+type encryptedMap struct {
+	inner StoredMap
+	aead  cipher.AEAD
+	mac   []byte
+}
+
+// NewEncryptedMap innerに暗号化して保存するStoredMapを作る
+// keyはNewKeyFileやKeyFile.Unlockで得たデータ鍵
+// innerに暗号化の印がなければ書き、あれば鍵が合っているかを確かめる
+func NewEncryptedMap(inner StoredMap, key []byte) (StoredMap, error) {
+	c, err := newEncryptedMap(inner, key)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := inner.Get(encryptedMarkerKey)
+	if IsNotExists(err) {
+		if value, err = c.marker(); err != nil {
+			return nil, err
+		}
+		return c, errors.Wrap(inner.Set(encryptedMarkerKey, value), "can't write encryption marker")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, _, err := c.open(c.innerKey(encryptedMarkerKey), value); err != nil {
+		return nil, errors.Wrap(err, "the key doesn't match the encrypted cache")
+	}
+
+	return c, nil
+}
+
+// IsEncrypted innerが暗号化されているか
+// 印を書く前に暗号化したStoredMapは暗号化したエントリがあるかで判断する
+func IsEncrypted(inner StoredMap) (bool, error) {
+	if inner.CanGet(encryptedMarkerKey) {
+		return true, nil
+	}
+
+	keys, err := inner.Keys(encryptedKeyPrefix)
+	if err != nil {
+		return false, err
+	}
+	return len(keys) > 0, nil
+}
+
+func newEncryptedMap(inner StoredMap, key []byte) (*encryptedMap, error) {
+	if len(key) != dataKeySize {
+		return nil, errors.Errorf("data key must be %d bytes", dataKeySize)
+	}
+
+	block, err := aes.NewCipher(key[:32])
+	if err != nil {
+		return nil, errors.Wrap(err, "can't create cipher")
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't create cipher")
+	}
+
+	return &encryptedMap{inner: inner, aead: aead, mac: key[32:]}, nil
+}
+
+// marker 暗号化の印の値
+func (c *encryptedMap) marker() ([]byte, error) {
+	return c.seal(encryptedMarkerKey, nil)
+}
+
+// innerKey 元のキーを保存に使うキーに置き換える
+func (c *encryptedMap) innerKey(key string) string {
+	h := hmac.New(sha256.New, c.mac)
+	h.Write([]byte(key))
+	return encryptedKeyPrefix + hex.EncodeToString(h.Sum(nil))
+}
+
+// seal 元のキーとdataを暗号化する
+// 保存に使うキーを追加データにして、他のエントリの値と入れ替えられないようにする
+func (c *encryptedMap) seal(key string, data []byte) ([]byte, error) {
+	plain := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(key)+len(data))
+	plain = append(plain[:binary.PutUvarint(plain, uint64(len(key)))], key...)
+	plain = append(plain, data...)
+
+	nonce := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(plain)+c.aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, errors.Wrap(err, "can't generate nonce")
+	}
+
+	return c.aead.Seal(nonce, nonce, plain, []byte(c.innerKey(key))), nil
+}
+
+// open 保存した値から元のキーとdataを取り出す
+func (c *encryptedMap) open(innerKey string, value []byte) (string, []byte, error) {
+	n := c.aead.NonceSize()
+	if len(value) < n {
+		return "", nil, errors.New("encrypted value is too short")
+	}
+
+	plain, err := c.aead.Open(nil, value[:n], value[n:], []byte(innerKey))
+	if err != nil {
+		return "", nil, errors.Wrap(err, "can't decrypt value. the key may be wrong")
+	}
+
+	l, m := binary.Uvarint(plain)
+	if m <= 0 || uint64(len(plain)-m) < l {
+		return "", nil, errors.New("broken encrypted value")
+	}
+
+	return string(plain[m : m+int(l)]), plain[m+int(l):], nil
+}
+
+func (c *encryptedMap) Get(key string) ([]byte, error) {
+	value, err := c.inner.Get(c.innerKey(key))
+	if IsNotExists(err) {
+		return nil, &NotExistsError{Key: key}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, data, err := c.open(c.innerKey(key), value)
+	return data, err
+}
+
+func (c *encryptedMap) CanGet(key string) bool {
+	return c.inner.CanGet(c.innerKey(key))
+}
+
+func (c *encryptedMap) Set(key string, data []byte) error {
+	value, err := c.seal(key, data)
+	if err != nil {
+		return err
+	}
+	return c.inner.Set(c.innerKey(key), value)
+}
+
+// Keys 元のキーを知るためにすべての値を復号する
+func (c *encryptedMap) Keys(prefix string) ([]string, error) {
+	entries, err := c.entries()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{}
+	for _, e := range entries {
+		if strings.HasPrefix(e.key, prefix) {
+			keys = append(keys, e.key)
+		}
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (c *encryptedMap) Delete(key string) error {
+	err := c.inner.Delete(c.innerKey(key))
+	if IsNotExists(err) {
+		return &NotExistsError{Key: key}
+	}
+	return err
+}
+
+func (c *encryptedMap) WriteBatch(batch *Batch) error {
+	inner := &Batch{}
+	for _, op := range batch.ops {
+		if op.delete {
+			inner.Delete(c.innerKey(op.key))
+			continue
+		}
+
+		value, err := c.seal(op.key, op.data)
+		if err != nil {
+			return err
+		}
+		inner.Set(c.innerKey(op.key), value)
+	}
+
+	return c.inner.WriteBatch(inner)
+}
+
+// plainEntry 復号したエントリ
+type plainEntry struct {
+	innerKey string
+	key      string
+	data     []byte
+}
+
+// entries 暗号化したすべてのエントリを復号する
+func (c *encryptedMap) entries() ([]plainEntry, error) {
+	innerKeys, err := c.inner.Keys(encryptedKeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []plainEntry{}
+	for _, ik := range innerKeys {
+		value, err := c.inner.Get(ik)
+		if err != nil {
+			return nil, err
+		}
+		key, data, err := c.open(ik, value)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, plainEntry{innerKey: ik, key: key, data: data})
+	}
+
+	return entries, nil
+}
+
+// plainEntries 暗号化していないすべてのエントリ
+func plainEntries(smap StoredMap) ([]plainEntry, error) {
+	keys, err := smap.Keys("")
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []plainEntry{}
+	for _, k := range keys {
+		if strings.HasPrefix(k, encryptedKeyPrefix) || k == encryptedMarkerKey {
+			continue
+		}
+		data, err := smap.Get(k)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, plainEntry{innerKey: k, key: k, data: data})
+	}
+
+	return entries, nil
+}
+
+// Reencrypt innerのエントリをoldKeyで復号してnewKeyで暗号化し直す
+// oldKeyがnilなら暗号化していないエントリを暗号化する
+// 暗号化の印もnewKeyで書き直す
+// ひとつのバッチで書き込むので、boltMapなら失敗した場合はどのエントリも変更しない
+// fileMapでは書き込みの途中で失敗すると一部のエントリだけ暗号化し直すことがある
+func Reencrypt(inner StoredMap, oldKey, newKey []byte) (int, error) {
+	var entries []plainEntry
+	if oldKey == nil {
+		var err error
+		if entries, err = plainEntries(inner); err != nil {
+			return 0, err
+		}
+	} else {
+		old, err := newEncryptedMap(inner, oldKey)
+		if err != nil {
+			return 0, err
+		}
+		if entries, err = old.entries(); err != nil {
+			return 0, err
+		}
+	}
+
+	c, err := newEncryptedMap(inner, newKey)
+	if err != nil {
+		return 0, err
+	}
+
+	batch := &Batch{}
+	for _, e := range entries {
+		value, err := c.seal(e.key, e.data)
+		if err != nil {
+			return 0, err
+		}
+		batch.Delete(e.innerKey)
+		batch.Set(c.innerKey(e.key), value)
+	}
+
+	marker, err := c.marker()
+	if err != nil {
+		return 0, err
+	}
+	batch.Set(encryptedMarkerKey, marker)
+
+	if err := inner.WriteBatch(batch); err != nil {
+		return 0, errors.Wrap(err, "can't write reencrypted entries")
+	}
+
+	return len(entries), nil
+}
+
+// KeyFile パスフレーズで保護したデータ鍵
+// パスフレーズからscryptで作った鍵でデータ鍵をAES-GCMで暗号化して保存する
+type KeyFile struct {
+	Version int    `json:"version"`
+	Salt    []byte `json:"salt"`
+	N       int    `json:"n"`
+	R       int    `json:"r"`
+	P       int    `json:"p"`
+	Key     []byte `json:"key"` // nonceと暗号化したデータ鍵
+}
+
+// NewKeyFile 新しいデータ鍵を作ってpassphraseで保護する
+// 鍵ファイルとデータ鍵を返す
+func NewKeyFile(passphrase []byte) (*KeyFile, []byte, error) {
+	key := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, nil, errors.Wrap(err, "can't generate data key")
+	}
+
+	k, err := protectKey(key, passphrase)
+	return k, key, err
+}
+
+func protectKey(key, passphrase []byte) (*KeyFile, error) {
+	k := &KeyFile{
+		Version: keyFileVersion,
+		Salt:    make([]byte, 16),
+		N:       scryptN,
+		R:       scryptR,
+		P:       scryptP,
+	}
+	if _, err := io.ReadFull(rand.Reader, k.Salt); err != nil {
+		return nil, errors.Wrap(err, "can't generate salt")
+	}
+
+	aead, err := k.aead(passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, errors.Wrap(err, "can't generate nonce")
+	}
+	k.Key = aead.Seal(nonce, nonce, key, nil)
+
+	return k, nil
+}
+
+func (k *KeyFile) aead(passphrase []byte) (cipher.AEAD, error) {
+	kek, err := scrypt.Key(passphrase, k.Salt, k.N, k.R, k.P, 32)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't derive key from passphrase")
+	}
+
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't create cipher")
+	}
+
+	aead, err := cipher.NewGCM(block)
+	return aead, errors.Wrap(err, "can't create cipher")
+}
+
+// Unlock passphraseでデータ鍵を取り出す
+func (k *KeyFile) Unlock(passphrase []byte) ([]byte, error) {
+	if k.Version != keyFileVersion {
+		return nil, errors.Errorf("unsupported key file version: %d", k.Version)
+	}
+
+	aead, err := k.aead(passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	n := aead.NonceSize()
+	if len(k.Key) < n {
+		return nil, errors.New("broken key file")
+	}
+
+	key, err := aead.Open(nil, k.Key[:n], k.Key[n:], nil)
+	if err != nil {
+		return nil, errors.New("wrong passphrase")
+	}
+
+	return key, nil
+}
+
+// DefaultKeyFilePath 鍵ファイルの標準の場所
+// キャッシュと一緒に持ち出されないように設定ファイルのディレクトリに置く
+func DefaultKeyFilePath() (string, error) {
+	dir, err := ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "cache.key"), nil
+}
+
+// ReadKeyFile 鍵ファイルを読む
+// ファイルがなければerrors.Causeがos.IsNotExistを満たすエラーを返す
+func ReadKeyFile(path string) (*KeyFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't read key file")
+	}
+
+	k := &KeyFile{}
+	if err := json.Unmarshal(data, k); err != nil {
+		return nil, errors.Wrap(err, "can't unmarshal key file")
+	}
+
+	return k, nil
+}
+
+// Write 鍵ファイルを書き出す
+// 一時ファイルに書いてから置き換えるので、途中で失敗しても元の鍵ファイルは壊れない
+func (k *KeyFile) Write(path string) error {
+	data, err := json.MarshalIndent(k, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "can't marshal key file")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return errors.Wrap(err, "can't prepare key file directory")
+	}
+
+	temp := path + ".tmp"
+	if err := ioutil.WriteFile(temp, data, 0600); err != nil {
+		return errors.Wrap(err, "can't write key file")
+	}
+
+	if err := os.Rename(temp, path); err != nil {
+		os.Remove(temp)
+		return errors.Wrap(err, "can't write key file")
+	}
+
+	return nil
+}
@@ -0,0 +1,165 @@
+package storedmap
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testDataKey(t *testing.T) []byte {
+	_, key, err := NewKeyFile([]byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestEncryptedMap(t *testing.T) {
+	inner := NewMemoryMap()
+	key := testDataKey(t)
+
+	c, err := NewEncryptedMap(inner, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testKeysDelete(t, c)
+	testWriteBatch(t, newEncryptedMemoryMap(t, key))
+
+	if err := c.Set("fund.12345", []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	if d, err := c.Get("fund.12345"); err != nil || string(d) != "secret" {
+		t.Fatalf("expected secret but got %s, %v", d, err)
+	}
+
+	// 元のキーも値も保存されない
+	innerKeys, _ := inner.Keys("")
+	for _, k := range innerKeys {
+		if strings.Contains(k, "12345") {
+			t.Errorf("the key is stored in plain text: %s", k)
+		}
+		d, _ := inner.Get(k)
+		if bytes.Contains(d, []byte("secret")) || bytes.Contains(d, []byte("12345")) {
+			t.Errorf("the value is stored in plain text: %s", d)
+		}
+	}
+
+	// 別の鍵では開けない
+	otherKey := testDataKey(t)
+	if _, err := NewEncryptedMap(inner, otherKey); err == nil {
+		t.Error("expected an error opening with another key")
+	}
+
+	other, _ := newEncryptedMap(inner, otherKey)
+	if other.CanGet("fund.12345") {
+		t.Error("expected the entry to be unreadable with another key")
+	}
+	if _, err := other.Keys(""); err == nil {
+		t.Error("expected an error listing keys with another key")
+	}
+}
+
+func TestIsEncrypted(t *testing.T) {
+	inner := NewMemoryMap()
+	inner.Set("fund.12345", []byte("fund"))
+
+	if encrypted, err := IsEncrypted(inner); err != nil || encrypted {
+		t.Fatalf("expected a plain map but got %v, %v", encrypted, err)
+	}
+
+	if _, err := NewEncryptedMap(inner, testDataKey(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	// エントリがなくても印で暗号化していることがわかる
+	inner.Delete("fund.12345")
+	if encrypted, err := IsEncrypted(inner); err != nil || !encrypted {
+		t.Errorf("expected an encrypted map but got %v, %v", encrypted, err)
+	}
+
+	// 印を書く前に暗号化したものはエントリでわかる
+	old := NewMemoryMap()
+	c, _ := newEncryptedMap(old, testDataKey(t))
+	c.Set("fund.12345", []byte("fund"))
+	if encrypted, err := IsEncrypted(old); err != nil || !encrypted {
+		t.Errorf("expected an encrypted map but got %v, %v", encrypted, err)
+	}
+}
+
+func newEncryptedMemoryMap(t *testing.T, key []byte) StoredMap {
+	c, err := NewEncryptedMap(NewMemoryMap(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestReencrypt(t *testing.T) {
+	inner := NewMemoryMap()
+	inner.Set("fund.12345", []byte("fund"))
+	inner.Set("string.a", []byte("string"))
+
+	key1 := testDataKey(t)
+	if n, err := Reencrypt(inner, nil, key1); err != nil || n != 2 {
+		t.Fatalf("expected 2 entries to be encrypted but got %d, %v", n, err)
+	}
+	if inner.CanGet("fund.12345") {
+		t.Error("expected the plain entry to be removed")
+	}
+
+	key2 := testDataKey(t)
+	if n, err := Reencrypt(inner, key1, key2); err != nil || n != 2 {
+		t.Fatalf("expected 2 entries to be reencrypted but got %d, %v", n, err)
+	}
+
+	// 印も新しい鍵で書き直す
+	c, err := NewEncryptedMap(inner, key2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d, err := c.Get("fund.12345"); err != nil || string(d) != "fund" {
+		t.Fatalf("expected fund but got %s, %v", d, err)
+	}
+	if keys, _ := c.Keys(""); len(keys) != 2 {
+		t.Errorf("unexpected keys: %v", keys)
+	}
+
+	if _, err := Reencrypt(inner, key1, key2); err == nil {
+		t.Error("expected an error with the old key")
+	}
+}
+
+func TestKeyFile(t *testing.T) {
+	tempDir := tempDir(t)
+	defer os.RemoveAll(tempDir)
+
+	path := filepath.Join(tempDir, "cache.key")
+
+	k, key, err := NewKeyFile([]byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Write(path); err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := ReadKeyFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unlocked, err := read.Unlock([]byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(unlocked, key) {
+		t.Error("expected the same data key")
+	}
+
+	if _, err := read.Unlock([]byte("wrong")); err == nil {
+		t.Error("expected an error with a wrong passphrase")
+	}
+}
@@ -0,0 +1,221 @@
+package storedmap
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// dataDirOverride SetDataDirで指定したディレクトリ
+var dataDirOverride string
+
+// SetDataDir キャッシュ、設定、データをすべて置くディレクトリを指定する
+// 空文字列なら環境変数から決める
+func SetDataDir(dir string) {
+	dataDirOverride = dir
+}
+
+// homeDir ひとつのディレクトリにすべて置く場合のディレクトリ
+// --data-dir、YAJIROBE_HOME、WindowsならAPPDATAの順に使う
+func homeDir() (string, bool, error) {
+	if dataDirOverride != "" {
+		return dataDirOverride, true, nil
+	}
+
+	if home := os.Getenv("YAJIROBE_HOME"); home != "" {
+		return home, true, nil
+	}
+
+	if runtime.GOOS == "windows" {
+		appdata := os.Getenv("APPDATA")
+		if appdata == "" {
+			return "", false, errors.New("APPDATA is not defined. Set YAJIROBE_HOME or --data-dir instead")
+		}
+		return filepath.Join(appdata, "yajirobe"), true, nil
+	}
+
+	return "", false, nil
+}
+
+// xdgDir XDG Base Directoryの環境変数envのディレクトリの下のyajirobeのディレクトリ
+// envがなければ$HOME/fallbackを使う
+func xdgDir(env, fallback string) (string, error) {
+	if base := os.Getenv(env); filepath.IsAbs(base) {
+		return filepath.Join(base, "yajirobe"), nil
+	}
+
+	home := os.Getenv("HOME")
+	if home == "" {
+		return "", errors.Errorf("can't determine the directory: neither HOME nor %s is set. Set YAJIROBE_HOME or --data-dir instead", env)
+	}
+
+	return filepath.Join(home, fallback, "yajirobe"), nil
+}
+
+func dir(env, fallback string) (string, error) {
+	home, ok, err := homeDir()
+	if err != nil || ok {
+		return home, err
+	}
+
+	return xdgDir(env, fallback)
+}
+
+// CacheDir ファンド情報のキャッシュを置くディレクトリ
+// 標準では$XDG_CACHE_HOME/yajirobe
+func CacheDir() (string, error) {
+	return dir("XDG_CACHE_HOME", ".cache")
+}
+
+// ConfigDir 設定ファイルを置くディレクトリ
+// 標準では$XDG_CONFIG_HOME/yajirobe
+func ConfigDir() (string, error) {
+	return dir("XDG_CONFIG_HOME", ".config")
+}
+
+// DataDir スキャン結果などのデータを置くディレクトリ
+// 標準では$XDG_DATA_HOME/yajirobe
+func DataDir() (string, error) {
+	return dir("XDG_DATA_HOME", filepath.Join(".local", "share"))
+}
+
+// legacyDir 以前にすべてを置いていたディレクトリ
+func legacyDir() string {
+	if home := os.Getenv("HOME"); home != "" {
+		return filepath.Join(home, ".yajirobe")
+	}
+	return ""
+}
+
+// MigrateLegacyDir 以前の$HOME/.yajirobeにある設定ファイルとキャッシュをXDGのディレクトリに移す
+// すべてをひとつのディレクトリに置く場合や、移す先にすでにファイルがある場合は何もしない
+// If logger is nil, MigrateLegacyDir uses NewNop as logger.
+func MigrateLegacyDir(logger *zap.Logger) error {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	if _, ok, err := homeDir(); err != nil || ok {
+		return err
+	}
+
+	legacy := legacyDir()
+	if legacy == "" {
+		return nil
+	}
+	if _, err := os.Stat(legacy); os.IsNotExist(err) {
+		return nil
+	}
+
+	configDir, err := ConfigDir()
+	if err != nil {
+		return err
+	}
+	cacheDir, err := CacheDir()
+	if err != nil {
+		return err
+	}
+
+	moves := []struct {
+		from, to string
+	}{
+		{filepath.Join(legacy, "config.json"), filepath.Join(configDir, "config.json")},
+		{filepath.Join(legacy, "cache.db"), filepath.Join(cacheDir, "cache.db")},
+		{filepath.Join(legacy, "cache"), filepath.Join(cacheDir, "cache")},
+	}
+
+	for _, m := range moves {
+		moved, err := move(m.from, m.to)
+		if err != nil {
+			return errors.Wrapf(err, "can't migrate %s to %s", m.from, m.to)
+		}
+		if moved {
+			logger.Sugar().Infof("migrated %s to %s", m.from, m.to)
+		}
+	}
+
+	// 空になっていれば以前のディレクトリを消す
+	os.Remove(filepath.Join(legacy, "cache.lock"))
+	os.Remove(legacy)
+
+	return nil
+}
+
+// move fromをtoに移す
+// fromがないかtoがすでにあればfalseを返す
+func move(from, to string) (bool, error) {
+	info, err := os.Stat(from)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := os.Stat(to); err == nil {
+		return false, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return false, err
+	}
+
+	if err := os.Rename(from, to); err == nil {
+		return true, nil
+	}
+
+	// 別のファイルシステムには移せないのでコピーする
+	if info.IsDir() {
+		err = copyDir(from, to)
+	} else {
+		err = copyFile(from, to, info.Mode())
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, os.RemoveAll(from)
+}
+
+func copyDir(from, to string) error {
+	return filepath.Walk(from, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(from, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(to, rel)
+
+		if info.IsDir() {
+			return os.MkdirAll(target, info.Mode())
+		}
+		return copyFile(path, target, info.Mode())
+	})
+}
+
+func copyFile(from, to string, mode os.FileMode) error {
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(to, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+
+	return dst.Close()
+}
@@ -0,0 +1,102 @@
+package storedmap
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestDirs(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("XDG base directories are not used on windows")
+	}
+
+	t.Setenv("YAJIROBE_HOME", "")
+	t.Setenv("HOME", "/home/user")
+	t.Setenv("XDG_CACHE_HOME", "")
+	t.Setenv("XDG_CONFIG_HOME", "/xdg/config")
+	t.Setenv("XDG_DATA_HOME", "relative/is/ignored")
+
+	for _, c := range []struct {
+		dir      func() (string, error)
+		expected string
+	}{
+		{CacheDir, "/home/user/.cache/yajirobe"},
+		{ConfigDir, "/xdg/config/yajirobe"},
+		{DataDir, "/home/user/.local/share/yajirobe"},
+	} {
+		d, err := c.dir()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d != c.expected {
+			t.Errorf("expected %s but got %s", c.expected, d)
+		}
+	}
+
+	t.Setenv("HOME", "")
+	if _, err := CacheDir(); err == nil {
+		t.Error("expected an error without HOME")
+	}
+
+	t.Setenv("YAJIROBE_HOME", "/yajirobe")
+	for _, dir := range []func() (string, error){CacheDir, ConfigDir, DataDir} {
+		if d, _ := dir(); d != "/yajirobe" {
+			t.Errorf("expected YAJIROBE_HOME but got %s", d)
+		}
+	}
+
+	SetDataDir("/data")
+	defer SetDataDir("")
+	for _, dir := range []func() (string, error){CacheDir, ConfigDir, DataDir} {
+		if d, _ := dir(); d != "/data" {
+			t.Errorf("expected --data-dir but got %s", d)
+		}
+	}
+}
+
+func TestMigrateLegacyDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("XDG base directories are not used on windows")
+	}
+
+	home := tempDir(t)
+	defer os.RemoveAll(home)
+
+	t.Setenv("YAJIROBE_HOME", "")
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CACHE_HOME", "")
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("XDG_DATA_HOME", "")
+
+	legacy := filepath.Join(home, ".yajirobe")
+	old := tempDirInfoCache(legacy)
+	old.Set("fund.12345", []byte("fund"))
+	if err := ioutil.WriteFile(filepath.Join(legacy, "config.json"), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := MigrateLegacyDir(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(home, ".config", "yajirobe", "config.json")); err != nil {
+		t.Errorf("expected config.json to be migrated: %v", err)
+	}
+
+	cacheDir, _ := CacheDir()
+	if !tempDirInfoCache(cacheDir).CanGet("fund.12345") {
+		t.Error("expected cache files to be migrated")
+	}
+
+	if _, err := os.Stat(legacy); !os.IsNotExist(err) {
+		t.Errorf("expected the legacy directory to be removed: %v", err)
+	}
+
+	// 移した後は何もしない
+	if err := MigrateLegacyDir(nil); err != nil {
+		t.Fatal(err)
+	}
+}
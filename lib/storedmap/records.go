@@ -0,0 +1,176 @@
+package storedmap
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// recordKeyPrefix レコードのキーの先頭
+// レコードの種類ごとに"record.<Name>."の下に保存する
+const recordKeyPrefix = "record."
+
+var recordNamePattern = regexp.MustCompile(`^[a-zA-Z0-9]+$`)
+
+// Upgrade あるバージョンのレコードのJSONを次のバージョンのJSONに変換する
+type Upgrade func(data json.RawMessage) (json.RawMessage, error)
+
+// RecordType StoredMapに保存するJSONのレコードの種類
+type RecordType struct {
+	// Name 種類の名前 [a-zA-Z0-9]+ の形式
+	Name string
+	// Version 今のスキーマのバージョン 1から始める
+	Version int
+	// Upgrades Upgrades[v]はバージョンvのレコードをv+1に変換する
+	// 古いバージョンのレコードは読むときに今のバージョンまで変換する
+	Upgrades map[int]Upgrade
+}
+
+// record 保存するときの形式
+type record struct {
+	Version   int             `json:"version"`
+	UpdatedAt time.Time       `json:"updated_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// Records ある種類のレコードをIDごとに読み書きする
+type Records struct {
+	smap StoredMap
+	typ  RecordType
+}
+
+// NewRecords typの種類のレコードをsmapに読み書きするRecordsを作る
+func NewRecords(smap StoredMap, typ RecordType) (*Records, error) {
+	if !recordNamePattern.MatchString(typ.Name) {
+		return nil, errors.Errorf("invalid record type name: %q", typ.Name)
+	}
+
+	if typ.Version < 1 {
+		return nil, errors.Errorf("record type %s: version must be positive", typ.Name)
+	}
+
+	for v := 1; v < typ.Version; v++ {
+		if typ.Upgrades[v] == nil {
+			return nil, errors.Errorf("record type %s: no upgrade from version %d", typ.Name, v)
+		}
+	}
+
+	return &Records{smap: smap, typ: typ}, nil
+}
+
+func (r *Records) prefix() string {
+	return recordKeyPrefix + r.typ.Name + "."
+}
+
+// key IDをStoredMapのキーにする
+// どのStoredMapでも区別できるようにIDは [a-zA-Z0-9.]+ の形式に限る
+func (r *Records) key(id string) (string, error) {
+	if id == "" || keyPattern.MatchString(id) {
+		return "", errors.Errorf("invalid record id: %q", id)
+	}
+	return r.prefix() + id, nil
+}
+
+// get keyのレコードを変換せずに読む
+func (r *Records) get(key string) (*record, error) {
+	data, err := r.smap.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	rec := &record{}
+	if err := json.Unmarshal(data, rec); err != nil {
+		return nil, errors.Wrapf(err, "can't unmarshal record %s", key)
+	}
+
+	return rec, nil
+}
+
+// Get idのレコードをvに読む
+// 古いバージョンのレコードは今のバージョンに変換してから読む
+// レコードがなければIsNotExistsがtrueになるエラーを返す
+func (r *Records) Get(id string, v interface{}) error {
+	key, err := r.key(id)
+	if err != nil {
+		return err
+	}
+
+	rec, err := r.get(key)
+	if err != nil {
+		return err
+	}
+
+	if rec.Version < 1 || rec.Version > r.typ.Version {
+		return errors.Errorf("record %s: unsupported version %d", key, rec.Version)
+	}
+
+	for ; rec.Version < r.typ.Version; rec.Version++ {
+		if rec.Data, err = r.typ.Upgrades[rec.Version](rec.Data); err != nil {
+			return errors.Wrapf(err, "can't upgrade record %s from version %d", key, rec.Version)
+		}
+	}
+
+	return errors.Wrapf(json.Unmarshal(rec.Data, v), "can't unmarshal record %s", key)
+}
+
+// Set vを今のバージョンのレコードとしてidに保存する
+func (r *Records) Set(id string, v interface{}) error {
+	key, err := r.key(id)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrapf(err, "can't marshal record %s", key)
+	}
+
+	rec, err := json.Marshal(&record{Version: r.typ.Version, UpdatedAt: time.Now(), Data: data})
+	if err != nil {
+		return errors.Wrapf(err, "can't marshal record %s", key)
+	}
+
+	return r.smap.Set(key, rec)
+}
+
+// Delete idのレコードを削除する
+func (r *Records) Delete(id string) error {
+	key, err := r.key(id)
+	if err != nil {
+		return err
+	}
+	return r.smap.Delete(key)
+}
+
+// UpdatedAt idのレコードを最後に保存した時刻
+// 時刻はレコードと一緒に保存するので、StoredMapを移しても変わらない
+func (r *Records) UpdatedAt(id string) (time.Time, error) {
+	key, err := r.key(id)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	rec, err := r.get(key)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return rec.UpdatedAt, nil
+}
+
+// IDs 保存しているレコードのIDの一覧 (昇順)
+func (r *Records) IDs() ([]string, error) {
+	keys, err := r.smap.Keys(r.prefix())
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(keys))
+	for i, k := range keys {
+		ids[i] = strings.TrimPrefix(k, r.prefix())
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,127 @@
+package storedmap
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+type testRate struct {
+	Pair string  `json:"pair"`
+	Rate float64 `json:"rate"`
+}
+
+var testRateV1 = RecordType{Name: "rate", Version: 1}
+
+// バージョン2でvalueをrateに名前を変えた
+var testRateV2 = RecordType{
+	Name:    "rate",
+	Version: 2,
+	Upgrades: map[int]Upgrade{
+		1: func(data json.RawMessage) (json.RawMessage, error) {
+			v := map[string]interface{}{}
+			if err := json.Unmarshal(data, &v); err != nil {
+				return nil, err
+			}
+			v["rate"] = v["value"]
+			delete(v, "value")
+			return json.Marshal(v)
+		},
+	},
+}
+
+func TestRecords(t *testing.T) {
+	smap := NewMemoryMap()
+
+	r, err := NewRecords(smap, testRateV2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := &testRate{}
+	if err := r.Get("USDJPY", v); !IsNotExists(err) {
+		t.Fatalf("expected NotExistsError but got %v", err)
+	}
+
+	if err := r.Set("USDJPY", &testRate{Pair: "USDJPY", Rate: 110.5}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Get("USDJPY", v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Pair != "USDJPY" || v.Rate != 110.5 {
+		t.Errorf("unexpected record: %+v", v)
+	}
+
+	if _, err := r.UpdatedAt("USDJPY"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 他の種類のレコードとは混ざらない
+	other, _ := NewRecords(smap, RecordType{Name: "fee", Version: 1})
+	other.Set("USDJPY", 1)
+
+	ids, err := r.IDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 || ids[0] != "USDJPY" {
+		t.Errorf("unexpected ids: %v", ids)
+	}
+
+	if err := r.Delete("USDJPY"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Get("USDJPY", v); !IsNotExists(err) {
+		t.Errorf("expected NotExistsError but got %v", err)
+	}
+
+	if err := r.Set("USD/JPY", v); err == nil {
+		t.Error("expected an error for the invalid id")
+	}
+}
+
+func TestRecordsUpgrade(t *testing.T) {
+	smap := NewMemoryMap()
+
+	r1, _ := NewRecords(smap, RecordType{Name: "rate", Version: 1})
+	if err := r1.Set("USDJPY", map[string]interface{}{"pair": "USDJPY", "value": 110.5}); err != nil {
+		t.Fatal(err)
+	}
+
+	r2, err := NewRecords(smap, testRateV2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := &testRate{}
+	if err := r2.Get("USDJPY", v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Rate != 110.5 {
+		t.Errorf("expected the upgraded rate but got %+v", v)
+	}
+
+	// 新しいバージョンのレコードは古いバージョンでは読めない
+	r2.Set("USDJPY", v)
+	if err := r1.Get("USDJPY", v); err == nil {
+		t.Error("expected an error for the newer version")
+	}
+}
+
+func TestNewRecordsValidation(t *testing.T) {
+	for _, typ := range []RecordType{
+		{Name: "", Version: 1},
+		{Name: "fx.rate", Version: 1},
+		{Name: "rate", Version: 0},
+		{Name: "rate", Version: 2},
+	} {
+		if _, err := NewRecords(NewMemoryMap(), typ); err == nil {
+			t.Errorf("expected an error for %+v", typ)
+		}
+	}
+
+	if _, err := NewRecords(NewMemoryMap(), testRateV1); err != nil {
+		t.Error(err)
+	}
+}
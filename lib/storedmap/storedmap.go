@@ -6,7 +6,10 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
-	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
@@ -14,17 +17,58 @@ import (
 
 // StoredMap バイト列を保存するインターフェイス
 type StoredMap interface {
-	// keyは `[a-zA-Z0-9.]+`` の形式
+	// fileMapではkeyは `[a-zA-Z0-9.]+` の形式 それ以外のキーにはSetできない
 	Get(key string) ([]byte, error)
 	CanGet(key string) bool
 	Set(key string, data []byte) error
+	// Keys prefixで始まるキーの一覧 (昇順)
+	Keys(prefix string) ([]string, error)
+	Delete(key string) error
+	// WriteBatch batchの変更をまとめて反映する
+	// 書き込みに失敗した場合にどこまで反映するかは実装による
+	// memoryMapとboltMapはどの変更も反映しない fileMapはファイルが壊れないことだけを保証する
+	WriteBatch(batch *Batch) error
 }
 
+type batchOp struct {
+	key     string
+	data    []byte
+	delete  bool
+	modTime time.Time // fileMapから移すときの更新時刻 boltMapだけが使う
+}
+
+// Batch StoredMap.WriteBatchでまとめて反映する変更
+// 同じキーへの変更は後のものが優先される
+type Batch struct {
+	ops []batchOp
+}
+
+// Set keyにdataを保存する変更を加える
+func (b *Batch) Set(key string, data []byte) {
+	b.ops = append(b.ops, batchOp{key: key, data: data})
+}
+
+// Delete keyを削除する変更を加える
+// keyが存在しなくてもエラーにはならない
+func (b *Batch) Delete(key string) {
+	b.ops = append(b.ops, batchOp{key: key, delete: true})
+}
+
+// Len 変更の数
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+// memoryMap 複数のgoroutineから使ってよい
 type memoryMap struct {
+	mu   sync.RWMutex
 	data map[string][]byte
 }
 
 func (c *memoryMap) Get(key string) ([]byte, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
 	if d, ok := c.data[key]; ok {
 		return d, nil
 	}
@@ -32,15 +76,60 @@ func (c *memoryMap) Get(key string) ([]byte, error) {
 }
 
 func (c *memoryMap) CanGet(key string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
 	_, ok := c.data[key]
 	return ok
 }
 
 func (c *memoryMap) Set(key string, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	c.data[key] = data
 	return nil
 }
 
+func (c *memoryMap) Keys(prefix string) ([]string, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	keys := []string{}
+	for k := range c.data {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (c *memoryMap) Delete(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.data[key]; !ok {
+		return &NotExistsError{Key: key}
+	}
+	delete(c.data, key)
+	return nil
+}
+
+func (c *memoryMap) WriteBatch(batch *Batch) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, op := range batch.ops {
+		if op.delete {
+			delete(c.data, op.key)
+		} else {
+			c.data[op.key] = op.data
+		}
+	}
+	return nil
+}
+
 // NewMemoryMap memoryCacheを作る
 func NewMemoryMap() StoredMap {
 	return &memoryMap{
@@ -48,6 +137,9 @@ func NewMemoryMap() StoredMap {
 	}
 }
 
+// fileMap キーごとにファイルを作って保存する
+// 書き込みは一時ファイルに書いてから置き換えるので、読み込み中のファイルが壊れることはない
+// 書き込み中はディレクトリごとロックするので、複数のプロセスから使ってよい
 type fileMap struct {
 	path   string
 	logger *zap.Logger
@@ -55,8 +147,16 @@ type fileMap struct {
 
 var keyPattern = regexp.MustCompile(`[^a-zA-Z0-9.]+`)
 
-func escapeKey(key string) string {
-	return keyPattern.ReplaceAllString(key, "")
+// validKey keyをそのままファイル名にできるか
+// ファイル名からキーを戻せるように、使えない文字を含むキーは書き換えずに拒否する
+func validKey(key string) error {
+	if key == "" {
+		return errors.New("key is empty")
+	}
+	if keyPattern.MatchString(key) {
+		return errors.Errorf("invalid key: %q", key)
+	}
+	return nil
 }
 
 func (c *fileMap) prepareDir() error {
@@ -68,8 +168,62 @@ func (c *fileMap) fundPath() string {
 }
 
 func (c *fileMap) fundFilePath(key string) string {
-	fname := escapeKey(key)
-	return filepath.Join(c.fundPath(), fname)
+	return filepath.Join(c.fundPath(), key)
+}
+
+func (c *fileMap) lockPath() string {
+	return filepath.Join(c.path, "cache.lock")
+}
+
+// lock 書き込みのためにキャッシュディレクトリをロックする
+// 返り値の関数でロックを解除する
+func (c *fileMap) lock() (func(), error) {
+	if err := c.prepareDir(); err != nil {
+		return nil, errors.Wrap(err, "can't prepare directory")
+	}
+
+	f, err := os.OpenFile(c.lockPath(), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't open lock file")
+	}
+
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, errors.Wrap(err, "can't lock cache directory")
+	}
+
+	return func() {
+		if err := unlockFile(f); err != nil {
+			c.logger.Sugar().Warnf("can't unlock cache directory: %v", err)
+		}
+		f.Close()
+	}, nil
+}
+
+// writeTemp dataを一時ファイルに書いてそのパスを返す
+// 一時ファイルの名前はキーに使えない文字を含むのでKeysには現れない
+func (c *fileMap) writeTemp(data []byte) (string, error) {
+	f, err := ioutil.TempFile(c.fundPath(), "tmp-")
+	if err != nil {
+		return "", errors.Wrap(err, "can't create temporary file")
+	}
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(0644)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", errors.Wrap(err, "can't write temporary file")
+	}
+
+	return f.Name(), nil
 }
 
 func (c *fileMap) Get(key string) ([]byte, error) {
@@ -77,19 +231,20 @@ func (c *fileMap) Get(key string) ([]byte, error) {
 		return nil, errors.New("Get method called with nil")
 	}
 
-	fullPath := c.fundFilePath(key)
+	if validKey(key) != nil {
+		return nil, &NotExistsError{Key: key}
+	}
 
-	if _, err := os.Stat(fullPath); os.IsNotExist(err) {
+	data, err := ioutil.ReadFile(c.fundFilePath(key))
+	if os.IsNotExist(err) {
 		c.logger.Sugar().Debugf("cache miss: %v", key)
 		return nil, &NotExistsError{Key: key}
 	}
-
-	c.logger.Sugar().Debugf("cache hit: %v", key)
-	data, err := ioutil.ReadFile(fullPath)
 	if err != nil {
 		return nil, errors.Wrap(err, "can't read cache file")
 	}
 
+	c.logger.Sugar().Debugf("cache hit: %v", key)
 	return data, nil
 }
 
@@ -99,24 +254,158 @@ func (c *fileMap) CanGet(key string) bool {
 }
 
 func (c *fileMap) Set(key string, data []byte) error {
-	key = escapeKey(key)
-	if key == "" {
-		return errors.New("key is empty")
+	if err := validKey(key); err != nil {
+		return err
 	}
 
-	if err := c.prepareDir(); err != nil {
-		return errors.Wrap(err, "can't prepare directory")
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	temp, err := c.writeTemp(data)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(temp, c.fundFilePath(key)); err != nil {
+		os.Remove(temp)
+		return errors.Wrap(err, "can't rename temporary file")
+	}
+
+	return nil
+}
+
+// ModTime ファイルの更新時刻 boltMapに移すときに使う
+func (c *fileMap) ModTime(key string) (time.Time, error) {
+	if validKey(key) != nil {
+		return time.Time{}, &NotExistsError{Key: key}
+	}
+
+	info, err := os.Stat(c.fundFilePath(key))
+	if os.IsNotExist(err) {
+		return time.Time{}, &NotExistsError{Key: key}
+	}
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "can't stat cache file")
+	}
+
+	return info.ModTime(), nil
+}
+
+func (c *fileMap) Keys(prefix string) ([]string, error) {
+	files, err := ioutil.ReadDir(c.fundPath())
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "can't read cache directory")
+	}
+
+	// ReadDirはファイル名の昇順で返す
+	// キーに使えない文字を含むファイルは書き込み中の一時ファイル
+	keys := []string{}
+	for _, f := range files {
+		if !f.IsDir() && !keyPattern.MatchString(f.Name()) && strings.HasPrefix(f.Name(), prefix) {
+			keys = append(keys, f.Name())
+		}
+	}
+
+	return keys, nil
+}
+
+func (c *fileMap) Delete(key string) error {
+	if validKey(key) != nil {
+		return &NotExistsError{Key: key}
+	}
+
+	unlock, err := c.lock()
+	if err != nil {
+		return err
 	}
+	defer unlock()
 
-	filePath := c.fundFilePath(key)
+	err = os.Remove(c.fundFilePath(key))
+	if os.IsNotExist(err) {
+		return &NotExistsError{Key: key}
+	}
+
+	return errors.Wrap(err, "can't remove cache file")
+}
+
+// WriteBatch 先にすべてのSetを一時ファイルに書いてから置き換える
+// 一時ファイルを書けなければどのファイルも変更しない
+// 置き換えや削除の途中で失敗した場合、それまでに変更したファイルは元に戻さない
+// ひとつひとつのファイルは置き換えるだけなので、書きかけのファイルは残らない
+func (c *fileMap) WriteBatch(batch *Batch) error {
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	type staged struct {
+		temp string
+		path string
+	}
+
+	// 同じキーへの変更は最後のものだけ反映する
+	// 使えない文字を含むキーのファイルはないので、削除は何もしない
+	last := map[string]int{}
+	for i, op := range batch.ops {
+		if err := validKey(op.key); err != nil {
+			if op.delete {
+				continue
+			}
+			return err
+		}
+		last[op.key] = i
+	}
+
+	files := []staged{}
+	removeTemps := func() {
+		for _, f := range files {
+			os.Remove(f.temp)
+		}
+	}
+
+	for i, op := range batch.ops {
+		if j, ok := last[op.key]; op.delete || !ok || j != i {
+			continue
+		}
 
-	return ioutil.WriteFile(filePath, data, 0644)
+		temp, err := c.writeTemp(op.data)
+		if err != nil {
+			removeTemps()
+			return err
+		}
+		files = append(files, staged{temp: temp, path: c.fundFilePath(op.key)})
+	}
+
+	for _, f := range files {
+		if err := os.Rename(f.temp, f.path); err != nil {
+			removeTemps()
+			return errors.Wrap(err, "can't rename temporary file")
+		}
+	}
+
+	for i, op := range batch.ops {
+		if j, ok := last[op.key]; !op.delete || !ok || j != i {
+			continue
+		}
+		if err := os.Remove(c.fundFilePath(op.key)); err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "can't remove cache file")
+		}
+	}
+
+	return nil
 }
 
 // NewFileMap creates a FileMap
 // If logger is nil, NewFileMap uses NewNop as logger.
 func NewFileMap(logger *zap.Logger) (StoredMap, error) {
-	dirPath, err := cachePath()
+	dirPath, err := CacheDir()
 	if err != nil {
 		return nil, err
 	}
@@ -151,15 +440,3 @@ func IsNotExists(e error) bool {
 func (e *NotExistsError) Error() string {
 	return fmt.Sprintf("no cached info of the key: %v", e.Key)
 }
-
-func cachePath() (string, error) {
-	if runtime.GOOS == "windows" {
-		appdata := os.Getenv("APPDATA")
-		if appdata == "" {
-			return "", errors.New("APPDATA is not defined")
-		}
-		return filepath.Join(appdata, "yajirobe"), nil
-	}
-
-	return filepath.Join(os.Getenv("HOME"), ".yajirobe"), nil
-}
@@ -1,8 +1,12 @@
 package storedmap
 
 import (
+	"bytes"
+	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 
 	"github.com/pkg/errors"
@@ -109,3 +113,254 @@ func TestIsNotExists(t *testing.T) {
 		t.Fatal("expected false but got true")
 	}
 }
+
+func testKeysDelete(t *testing.T, c StoredMap) {
+	for _, k := range []string{"fund.2", "fund.1", "string.a"} {
+		if err := c.Set(k, []byte("data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keys, err := c.Keys("fund.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0] != "fund.1" || keys[1] != "fund.2" {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+
+	if err := c.Delete("fund.1"); err != nil {
+		t.Fatal(err)
+	}
+	if c.CanGet("fund.1") {
+		t.Error("expected fund.1 to be deleted")
+	}
+
+	if err := c.Delete("fund.1"); !IsNotExists(err) {
+		t.Errorf("expected NotExistsError but got %v", err)
+	}
+}
+
+func TestFileKeysDelete(t *testing.T) {
+	tempDir := tempDir(t)
+	defer os.RemoveAll(tempDir)
+
+	testKeysDelete(t, tempDirInfoCache(tempDir))
+}
+
+func TestFileInvalidKey(t *testing.T) {
+	tempDir := tempDir(t)
+	defer os.RemoveAll(tempDir)
+
+	c := tempDirInfoCache(tempDir)
+
+	// 使えない文字を取り除いて別のキーとして保存しない
+	if err := c.Set("fund.a-b", []byte("data")); err == nil {
+		t.Fatal("expected an error for the invalid key")
+	}
+	if c.CanGet("fund.ab") {
+		t.Error("expected nothing to be written")
+	}
+	if _, err := c.Get("fund.a-b"); !IsNotExists(err) {
+		t.Errorf("expected NotExistsError but got %v", err)
+	}
+
+	batch := &Batch{}
+	batch.Delete("../cache.lock")
+	if err := c.WriteBatch(batch); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(c.(*fileMap).lockPath()); err != nil {
+		t.Errorf("expected the lock file to be kept: %v", err)
+	}
+}
+
+func TestMemoryKeysDelete(t *testing.T) {
+	testKeysDelete(t, NewMemoryMap())
+}
+
+func testWriteBatch(t *testing.T, c StoredMap) {
+	c.Set("a", []byte("old"))
+	c.Set("b", []byte("old"))
+
+	batch := &Batch{}
+	batch.Set("a", []byte("first"))
+	batch.Set("a", []byte("new"))
+	batch.Set("c", []byte("new"))
+	batch.Delete("b")
+	batch.Delete("notexists")
+
+	if err := c.WriteBatch(batch); err != nil {
+		t.Fatal(err)
+	}
+
+	if d, _ := c.Get("a"); string(d) != "new" {
+		t.Errorf("expected new but got %s", d)
+	}
+	if d, _ := c.Get("c"); string(d) != "new" {
+		t.Errorf("expected new but got %s", d)
+	}
+	if c.CanGet("b") {
+		t.Error("expected b to be deleted")
+	}
+
+	keys, err := c.Keys("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0] != "a" || keys[1] != "c" {
+		t.Errorf("unexpected keys: %v", keys)
+	}
+}
+
+func TestFileWriteBatch(t *testing.T) {
+	tempDir := tempDir(t)
+	defer os.RemoveAll(tempDir)
+
+	testWriteBatch(t, tempDirInfoCache(tempDir))
+}
+
+func TestMemoryWriteBatch(t *testing.T) {
+	testWriteBatch(t, NewMemoryMap())
+}
+
+func TestFileWriteBatchEmptyKey(t *testing.T) {
+	tempDir := tempDir(t)
+	defer os.RemoveAll(tempDir)
+
+	c := tempDirInfoCache(tempDir)
+
+	batch := &Batch{}
+	batch.Set("a", []byte("data"))
+	batch.Set("---", []byte("data"))
+
+	if err := c.WriteBatch(batch); err == nil {
+		t.Fatal("expected an error for the empty key")
+	}
+	if c.CanGet("a") {
+		t.Error("expected nothing to be written")
+	}
+}
+
+func TestFileConcurrentSet(t *testing.T) {
+	tempDir := tempDir(t)
+	defer os.RemoveAll(tempDir)
+
+	const size = 64 * 1024
+	const writers = 8
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(b byte) {
+			defer wg.Done()
+			// 別のプロセスの代わりにfileMapを別々に作る
+			c := tempDirInfoCache(tempDir)
+			for j := 0; j < 20; j++ {
+				if err := c.Set("key", bytes.Repeat([]byte{b}, size)); err != nil {
+					t.Error(err)
+					return
+				}
+				batch := &Batch{}
+				batch.Set("key", bytes.Repeat([]byte{b}, size))
+				batch.Set("other", []byte{b})
+				if err := c.WriteBatch(batch); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(byte('a' + i))
+	}
+
+	done := make(chan struct{})
+	readerErr := make(chan error, 1)
+	go func() {
+		c := tempDirInfoCache(tempDir)
+		for {
+			select {
+			case <-done:
+				readerErr <- nil
+				return
+			default:
+			}
+
+			data, err := c.Get("key")
+			if IsNotExists(err) {
+				continue
+			}
+			if err != nil {
+				readerErr <- err
+				return
+			}
+			if len(data) != size || !bytes.Equal(data, bytes.Repeat(data[:1], size)) {
+				readerErr <- errors.Errorf("read a partially written value of %d bytes", len(data))
+				return
+			}
+		}
+	}()
+
+	wg.Wait()
+	close(done)
+	if err := <-readerErr; err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := tempDirInfoCache(tempDir).Keys("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0] != "key" || keys[1] != "other" {
+		t.Errorf("expected no temporary files but got %v", keys)
+	}
+}
+
+func TestFileKeysSkipTemporaryFiles(t *testing.T) {
+	tempDir := tempDir(t)
+	defer os.RemoveAll(tempDir)
+
+	c := tempDirInfoCache(tempDir)
+	c.Set("key", []byte("data"))
+
+	// 書き込み中に落ちたときに残る一時ファイル
+	if err := ioutil.WriteFile(filepath.Join(tempDir, "cache", "tmp-12345"), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := c.Keys("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0] != "key" {
+		t.Errorf("unexpected keys: %v", keys)
+	}
+}
+
+func TestMemoryConcurrent(t *testing.T) {
+	c := NewMemoryMap()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := fmt.Sprintf("key%d", i)
+			for j := 0; j < 100; j++ {
+				c.Set(key, []byte{byte(j)})
+				c.Get(key)
+				c.CanGet(key)
+				c.Keys("key")
+
+				batch := &Batch{}
+				batch.Set("shared", []byte{byte(i)})
+				batch.Delete(key)
+				c.WriteBatch(batch)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	keys, _ := c.Keys("")
+	if len(keys) != 1 || keys[0] != "shared" {
+		t.Errorf("unexpected keys: %v", keys)
+	}
+}
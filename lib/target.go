@@ -0,0 +1,209 @@
+package yajirobe
+
+import (
+	"encoding/json"
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// TargetNode 階層化した目標アロケーション
+// 子を持たないノードはClassのアセットクラスを表す
+// Ratioは親ノードに対する割合で、兄弟ノードの合計が1になるようにする
+type TargetNode struct {
+	Name     string        `json:"name,omitempty"`
+	Class    AssetClass    `json:"class,omitempty"`
+	Ratio    float64       `json:"ratio"`
+	Children []*TargetNode `json:"children,omitempty"`
+
+	noClass bool // JSONにclassがなかった
+}
+
+// MarshalJSON implements json.Marshaler
+// Otherの葉も読み直せるように、葉のclassは省略しない
+func (n *TargetNode) MarshalJSON() ([]byte, error) {
+	type node TargetNode
+	if !n.IsLeaf() {
+		return json.Marshal((*node)(n))
+	}
+	return json.Marshal(&struct {
+		*node
+		Class AssetClass `json:"class"`
+	}{(*node)(n), n.Class})
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+// classがないことをValidateで検査できるように覚えておく
+func (n *TargetNode) UnmarshalJSON(data []byte) error {
+	type node TargetNode
+	v := &struct {
+		*node
+		Class *AssetClass `json:"class"`
+	}{node: (*node)(n)}
+	if err := json.Unmarshal(data, v); err != nil {
+		return err
+	}
+
+	n.noClass = v.Class == nil
+	if v.Class != nil {
+		n.Class = *v.Class
+	}
+	return nil
+}
+
+// NewTargetTree 階層のない目標アロケーションを1段の木にする
+func NewTargetTree(target AllocationTarget) *TargetNode {
+	root := &TargetNode{Ratio: 1}
+
+	for _, class := range AssetClasses() {
+		if t, e := target[class]; e {
+			root.Children = append(root.Children, &TargetNode{Class: class, Ratio: t})
+		}
+	}
+
+	return root
+}
+
+// IsLeaf アセットクラスを表すノードならtrue
+func (n *TargetNode) IsLeaf() bool {
+	return len(n.Children) == 0
+}
+
+// Label 表示名
+func (n *TargetNode) Label() string {
+	if n.Name == "" && n.IsLeaf() {
+		return n.Class.String()
+	}
+	return n.Name
+}
+
+// IsNested 2段以上の階層があればtrue
+func (n *TargetNode) IsNested() bool {
+	for _, c := range n.Children {
+		if !c.IsLeaf() {
+			return true
+		}
+	}
+	return false
+}
+
+// AllocationTarget 葉のアセットクラスごとの全体に対する割合
+func (n *TargetNode) AllocationTarget() AllocationTarget {
+	target := AllocationTarget{}
+	n.flatten(1, target)
+	return target
+}
+
+func (n *TargetNode) flatten(ratio float64, target AllocationTarget) {
+	if n.IsLeaf() {
+		target[n.Class] += ratio
+		return
+	}
+
+	for _, c := range n.Children {
+		c.flatten(ratio*c.Ratio, target)
+	}
+}
+
+// leaves 配下のアセットクラス
+func (n *TargetNode) leaves() []AssetClass {
+	if n.IsLeaf() {
+		return []AssetClass{n.Class}
+	}
+
+	classes := []AssetClass{}
+	for _, c := range n.Children {
+		classes = append(classes, c.leaves()...)
+	}
+	return classes
+}
+
+// Validate 割合の合計やアセットクラスの重複、classも子もないノードを検査する
+func (n *TargetNode) Validate() error {
+	seen := map[AssetClass]bool{}
+	return n.validate(seen)
+}
+
+func (n *TargetNode) validate(seen map[AssetClass]bool) error {
+	if n.IsLeaf() {
+		if n.noClass {
+			return errors.New("target: a node without children must have a class")
+		}
+		if seen[n.Class] {
+			return errors.Errorf("target: %v appears more than once", n.Class)
+		}
+		seen[n.Class] = true
+		return nil
+	}
+
+	sum := 0.0
+	for _, c := range n.Children {
+		if c.Ratio < 0 {
+			return errors.Errorf("target: ratio of %s must not be negative", c.Label())
+		}
+		sum += c.Ratio
+		if err := c.validate(seen); err != nil {
+			return err
+		}
+	}
+
+	if math.Abs(sum-1) > 1e-6 {
+		return errors.Errorf("target: ratios under %q sum up to %.4f, expected 1", n.Label(), sum)
+	}
+
+	return nil
+}
+
+// withAllocationTarget 階層を保ったまま、葉の全体に対する割合をtargetにした木を返す
+// targetにない葉は0になり、木にないアセットクラスは最上位に追加する
+func (n *TargetNode) withAllocationTarget(target AllocationTarget) *TargetNode {
+	tree := n.clone()
+
+	seen := map[AssetClass]bool{}
+	for _, c := range tree.leaves() {
+		seen[c] = true
+	}
+	for _, c := range AssetClasses() {
+		if t, e := target[c]; e && t > 0 && !seen[c] {
+			tree.Children = append(tree.Children, &TargetNode{Class: c})
+		}
+	}
+
+	tree.assignRatios(target)
+	return tree
+}
+
+func (n *TargetNode) clone() *TargetNode {
+	c := *n
+	c.Children = make([]*TargetNode, len(n.Children))
+	for i, child := range n.Children {
+		c.Children[i] = child.clone()
+	}
+	return &c
+}
+
+// weight 配下の葉のtargetでの割合の合計
+func (n *TargetNode) weight(target AllocationTarget) float64 {
+	if n.IsLeaf() {
+		return target[n.Class]
+	}
+
+	sum := 0.0
+	for _, c := range n.Children {
+		sum += c.weight(target)
+	}
+	return sum
+}
+
+// assignRatios 子ノードの割合を配下の葉の割合の合計から決める
+// 配下の合計が0のノードは元の割合を保つ
+func (n *TargetNode) assignRatios(target AllocationTarget) {
+	total := n.weight(target)
+
+	for _, c := range n.Children {
+		if total > 0 {
+			c.Ratio = c.weight(target) / total
+		}
+		c.assignRatios(target)
+	}
+}
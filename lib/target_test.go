@@ -0,0 +1,125 @@
+package yajirobe
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func stocksAndBonds() *TargetNode {
+	return &TargetNode{
+		Ratio: 1,
+		Children: []*TargetNode{
+			{Name: "Stocks", Ratio: 0.7, Children: []*TargetNode{
+				{Class: DomesticStocks, Ratio: 0.3},
+				{Class: InternationalStocks, Ratio: 0.55},
+				{Class: EmergingStocks, Ratio: 0.15},
+			}},
+			{Class: DomesticBonds, Ratio: 0.3},
+		},
+	}
+}
+
+func TestTargetTreeAllocationTarget(t *testing.T) {
+	target := stocksAndBonds().AllocationTarget()
+
+	expected := AllocationTarget{
+		DomesticStocks:      0.21,
+		InternationalStocks: 0.385,
+		EmergingStocks:      0.105,
+		DomesticBonds:       0.3,
+	}
+
+	for class, r := range expected {
+		if math.Abs(target[class]-r) > 1e-9 {
+			t.Errorf("%v expected %.3f but got %.3f", class, r, target[class])
+		}
+	}
+}
+
+func TestTargetTreeValidate(t *testing.T) {
+	tree := stocksAndBonds()
+	if err := tree.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	tree.Children[0].Children[0].Ratio = 0.5
+	if err := tree.Validate(); err == nil {
+		t.Error("expected error for ratios not summing up to 1")
+	}
+
+	tree = stocksAndBonds()
+	tree.Children[1].Class = DomesticStocks
+	if err := tree.Validate(); err == nil {
+		t.Error("expected error for a duplicated class")
+	}
+}
+
+func TestTargetTreeValidateNoClass(t *testing.T) {
+	tree := &TargetNode{}
+	data := `{"ratio": 1, "children": [{"class": "DomesticStocks", "ratio": 0.5}, {"name": "Bonds", "ratio": 0.5}]}`
+	if err := json.Unmarshal([]byte(data), tree); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Validate(); err == nil {
+		t.Error("expected error for a leaf without class")
+	}
+
+	// Otherの葉は保存しても読み直せる
+	tree = NewTargetTree(AllocationTarget{DomesticStocks: 0.5, Other: 0.5})
+	saved, err := json.Marshal(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := &TargetNode{}
+	if err := json.Unmarshal(saved, read); err != nil {
+		t.Fatal(err)
+	}
+	if err := read.Validate(); err != nil {
+		t.Errorf("expected %s to be valid but got %v", saved, err)
+	}
+}
+
+func TestNewTargetTree(t *testing.T) {
+	tree := NewTargetTree(AllocationTarget{
+		DomesticStocks: 0.4,
+		DomesticBonds:  0.6,
+	})
+
+	if tree.IsNested() {
+		t.Error("expected a flat tree")
+	}
+
+	if len(tree.Children) != 2 || tree.Children[0].Class != DomesticStocks {
+		t.Errorf("expected children ordered by AssetClasses but got %v", tree.Children)
+	}
+}
+
+func TestWithAllocationTarget(t *testing.T) {
+	original := stocksAndBonds()
+
+	tree := original.withAllocationTarget(AllocationTarget{
+		DomesticStocks:      0.2,
+		InternationalStocks: 0.4,
+		EmergingStocks:      0.1,
+		DomesticBonds:       0.2,
+		InternationalBonds:  0.1,
+	})
+
+	if err := tree.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 階層は保ったまま、木になかった海外債券は最上位に加わる
+	if len(tree.Children) != 3 || tree.Children[0].Name != "Stocks" || tree.Children[2].Class != InternationalBonds {
+		t.Fatalf("unexpected tree: %+v", tree.Children)
+	}
+	if math.Abs(tree.Children[0].Ratio-0.7) > 1e-9 || math.Abs(tree.Children[0].Children[0].Ratio-0.2/0.7) > 1e-9 {
+		t.Errorf("unexpected ratios: %v %v", tree.Children[0].Ratio, tree.Children[0].Children[0].Ratio)
+	}
+
+	// 元の木は変わらない
+	if original.Children[0].Children[0].Ratio != 0.3 || len(original.Children) != 2 {
+		t.Error("original tree was modified")
+	}
+}
@@ -0,0 +1,176 @@
+package yajirobe
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// 標準のHTTPリクエストの設定
+const (
+	defaultHTTPTimeout   = 30 * time.Second
+	defaultHTTPRetries   = 3
+	defaultHTTPRetryWait = time.Second
+	defaultHTTPInterval  = 200 * time.Millisecond
+)
+
+// HTTPOption SBIへのHTTPリクエストの設定
+type HTTPOption struct {
+	// Timeout 1回のリクエストのタイムアウト 0なら30秒
+	Timeout time.Duration
+	// Retries GETが失敗したときに再試行する回数 0なら3回、負なら再試行しない
+	Retries int
+	// RetryWait 最初の再試行までの待ち時間 再試行のたびに倍にする 0なら1秒
+	RetryWait time.Duration
+	// Interval すべてのリクエストの間隔 0なら200ms、負なら間隔を空けない
+	Interval time.Duration
+}
+
+func (o HTTPOption) withDefaults() HTTPOption {
+	if o.Timeout <= 0 {
+		o.Timeout = defaultHTTPTimeout
+	}
+	if o.Retries == 0 {
+		o.Retries = defaultHTTPRetries
+	} else if o.Retries < 0 {
+		o.Retries = 0
+	}
+	if o.RetryWait <= 0 {
+		o.RetryWait = defaultHTTPRetryWait
+	}
+	if o.Interval == 0 {
+		o.Interval = defaultHTTPInterval
+	}
+	return o
+}
+
+// sbiTransport surfの下でSBIへのリクエストを送るhttp.RoundTripper
+// リクエストごとのタイムアウト、全体のリクエストの間隔、GETの再試行を受け持つ
+// 複数のブラウザで共有する
+type sbiTransport struct {
+	base    http.RoundTripper
+	option  HTTPOption
+	limiter *rateLimiter
+	logger  *zap.SugaredLogger
+
+	mu  sync.Mutex
+	ctx context.Context
+}
+
+func newSbiTransport(option HTTPOption, logger *zap.SugaredLogger) *sbiTransport {
+	option = option.withDefaults()
+	return &sbiTransport{
+		base:    http.DefaultTransport.(*http.Transport).Clone(),
+		option:  option,
+		limiter: newRateLimiter(option.Interval),
+		logger:  logger,
+		ctx:     context.Background(),
+	}
+}
+
+// setContext これから送るリクエストをctxで取り消せるようにする
+// surfはリクエストにcontextを渡せないのでここで付ける
+func (t *sbiTransport) setContext(ctx context.Context) {
+	t.mu.Lock()
+	t.ctx = ctx
+	t.mu.Unlock()
+}
+
+func (t *sbiTransport) context() context.Context {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.ctx
+}
+
+// retryable 再試行してよいリクエストか
+// ボディを送り直せないので、副作用のないGETとHEADに限る
+func retryable(req *http.Request) bool {
+	return req.Method == http.MethodGet || req.Method == http.MethodHead
+}
+
+// temporary 再試行すれば成功するかもしれない失敗か
+func temporary(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *sbiTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := t.context()
+
+	retries := 0
+	if retryable(req) {
+		retries = t.option.Retries
+	}
+
+	wait := t.option.RetryWait
+	for attempt := 0; ; attempt++ {
+		if err := t.limiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+
+		resp, err := t.roundTrip(ctx, req)
+		if ctx.Err() != nil || attempt >= retries || !temporary(resp, err) {
+			return resp, err
+		}
+
+		if err != nil {
+			t.logger.Debugf("http: %s %s: %v, retry in %v", req.Method, req.URL, err, wait)
+		} else {
+			t.logger.Debugf("http: %s %s: %s, retry in %v", req.Method, req.URL, resp.Status, wait)
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		wait *= 2
+	}
+}
+
+// roundTrip タイムアウトを付けて1回だけリクエストを送る
+// タイムアウトはレスポンスのボディを読み終えるまでを含む
+func (t *sbiTransport) roundTrip(ctx context.Context, req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.option.Timeout)
+
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelBody 閉じたときにリクエストのcontextも取り消すボディ
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// newSurfTransport すべてのリクエストをrtに送る*http.Transportを作る
+// surfのSetTransportは*http.Transportしか受け取らないので、
+// httpとhttpsのプロトコルとしてrtを登録する
+func newSurfTransport(rt http.RoundTripper) *http.Transport {
+	t := &http.Transport{}
+	t.RegisterProtocol("http", rt)
+	t.RegisterProtocol("https", rt)
+	return t
+}
@@ -0,0 +1,163 @@
+package yajirobe
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// flakyServer 最初のfailures回は503を返すサーバー
+func flakyServer(failures int) (*httptest.Server, func() int) {
+	var mu sync.Mutex
+	count := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		count++
+		n := count
+		mu.Unlock()
+
+		if n <= failures {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+
+	return server, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return count
+	}
+}
+
+func testTransport(option HTTPOption) *sbiTransport {
+	if option.RetryWait == 0 {
+		option.RetryWait = time.Millisecond
+	}
+	if option.Interval == 0 {
+		option.Interval = -1
+	}
+	return newSbiTransport(option, zap.NewNop().Sugar())
+}
+
+func TestTransportRetry(t *testing.T) {
+	server, count := flakyServer(2)
+	defer server.Close()
+
+	// surfと同じように*http.Transportを通して使う
+	client := &http.Client{Transport: newSurfTransport(testTransport(HTTPOption{}))}
+
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusOK || string(body) != "ok" {
+		t.Errorf("expected 200 ok but got %d %q", resp.StatusCode, body)
+	}
+	if n := count(); n != 3 {
+		t.Errorf("expected 3 requests but got %d", n)
+	}
+}
+
+func TestTransportRetryLimit(t *testing.T) {
+	server, count := flakyServer(10)
+	defer server.Close()
+
+	client := &http.Client{Transport: testTransport(HTTPOption{Retries: 2})}
+
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 but got %d", resp.StatusCode)
+	}
+	if n := count(); n != 3 {
+		t.Errorf("expected 3 requests but got %d", n)
+	}
+}
+
+func TestTransportNoRetryPost(t *testing.T) {
+	server, count := flakyServer(1)
+	defer server.Close()
+
+	client := &http.Client{Transport: testTransport(HTTPOption{})}
+
+	resp, err := client.Post(server.URL, "text/plain", strings.NewReader("form"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 but got %d", resp.StatusCode)
+	}
+	if n := count(); n != 1 {
+		t.Errorf("expected 1 request but got %d", n)
+	}
+}
+
+func TestTransportTimeout(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(done)
+
+	client := &http.Client{Transport: testTransport(HTTPOption{Timeout: 20 * time.Millisecond, Retries: -1})}
+
+	start := time.Now()
+	if _, err := client.Get(server.URL); err == nil {
+		t.Error("expected a timeout error")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("expected to time out soon but took %v", d)
+	}
+}
+
+func TestTransportCancel(t *testing.T) {
+	server, count := flakyServer(10)
+	defer server.Close()
+
+	transport := testTransport(HTTPOption{RetryWait: time.Hour})
+	client := &http.Client{Transport: transport}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	transport.setContext(ctx)
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	// 再試行を待っている間に取り消す
+	if _, err := client.Get(server.URL); err == nil {
+		t.Error("expected an error after cancel")
+	}
+	if n := count(); n != 1 {
+		t.Errorf("expected 1 request but got %d", n)
+	}
+
+	// 取り消した後はリクエストを送らない
+	if _, err := client.Get(server.URL); err == nil {
+		t.Error("expected an error after cancel")
+	}
+	if n := count(); n != 1 {
+		t.Errorf("expected 1 request but got %d", n)
+	}
+}
@@ -0,0 +1,136 @@
+package yajirobe
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/term"
+)
+
+// KeyCode 文字以外のキー
+type KeyCode int
+
+const (
+	// KeyRune 文字キー
+	KeyRune KeyCode = iota
+	// KeyUp ↑
+	KeyUp
+	// KeyDown ↓
+	KeyDown
+	// KeyLeft ←
+	KeyLeft
+	// KeyRight →
+	KeyRight
+	// KeyEnter Enter
+	KeyEnter
+	// KeyBackspace Backspace
+	KeyBackspace
+	// KeyEscape Esc
+	KeyEscape
+	// KeyCtrlC Ctrl-C
+	KeyCtrlC
+)
+
+// Key キー入力
+// CodeがKeyRuneのときはRuneに入力された文字が入る
+type Key struct {
+	Code KeyCode
+	Rune rune
+}
+
+// readKey 端末から1つのキー入力を読む
+func readKey(r *bufio.Reader) (Key, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return Key{}, err
+	}
+
+	switch b {
+	case 0x1b:
+		// 続きがなければEscキーそのもの
+		if r.Buffered() == 0 {
+			return Key{Code: KeyEscape}, nil
+		}
+		if next, _ := r.Peek(1); next[0] != '[' && next[0] != 'O' {
+			return Key{Code: KeyEscape}, nil
+		}
+		r.ReadByte()
+
+		c, err := r.ReadByte()
+		if err != nil {
+			return Key{}, err
+		}
+		switch c {
+		case 'A':
+			return Key{Code: KeyUp}, nil
+		case 'B':
+			return Key{Code: KeyDown}, nil
+		case 'C':
+			return Key{Code: KeyRight}, nil
+		case 'D':
+			return Key{Code: KeyLeft}, nil
+		}
+		return Key{Code: KeyEscape}, nil
+	case '\r', '\n':
+		return Key{Code: KeyEnter}, nil
+	case 0x7f, 0x08:
+		return Key{Code: KeyBackspace}, nil
+	case 0x03:
+		return Key{Code: KeyCtrlC}, nil
+	}
+
+	if err := r.UnreadByte(); err != nil {
+		return Key{}, err
+	}
+	c, _, err := r.ReadRune()
+	if err != nil {
+		return Key{}, err
+	}
+	return Key{Code: KeyRune, Rune: c}, nil
+}
+
+// RunDashboard 端末をrawモードにしてDashboardを操作する
+// qかCtrl-Cで終了するまで戻らない
+func RunDashboard(in *os.File, out io.Writer, d *Dashboard) error {
+	fd := int(in.Fd())
+	if !term.IsTerminal(fd) {
+		return errors.New("tui requires a terminal")
+	}
+
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return errors.Wrap(err, "can't make the terminal raw")
+	}
+	defer term.Restore(fd, state)
+
+	// 代替画面に切り替えてカーソルを隠す
+	fmt.Fprint(out, "\x1b[?1049h\x1b[?25l")
+	defer fmt.Fprint(out, "\x1b[?25h\x1b[?1049l")
+
+	r := bufio.NewReader(in)
+	for {
+		width, _, err := term.GetSize(fd)
+		if err != nil {
+			width = 80
+		}
+
+		// rawモードでは改行で行頭に戻らない
+		view := strings.Replace(d.View(width), "\n", "\x1b[K\r\n", -1)
+		if _, err := fmt.Fprint(out, "\x1b[H"+view+"\x1b[K\x1b[J"); err != nil {
+			return errors.Wrap(err, "can't draw the dashboard")
+		}
+
+		k, err := readKey(r)
+		if err != nil {
+			return errors.Wrap(err, "can't read a key")
+		}
+
+		if d.HandleKey(k) {
+			return nil
+		}
+	}
+}
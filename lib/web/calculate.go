@@ -0,0 +1,161 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/masaedw/yajirobe/lib"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// maxRequestSize 計算APIが受け付けるリクエストの大きさ
+const maxRequestSize = 1 << 20
+
+// Holding 計算APIに渡す保有資産
+// Compositionがあればアセットクラスごとに振り分ける
+type Holding struct {
+	Code             string               `json:"code"`
+	Name             string               `json:"name"`
+	Class            yajirobe.AssetClass  `json:"class"`
+	Composition      yajirobe.Composition `json:"composition,omitempty"`
+	Amount           int                  `json:"amount"`
+	AcquisitionPrice float64              `json:"acquisition_price"`
+	CurrentPrice     float64              `json:"current_price"`
+}
+
+// CalculateRequest 計算APIのリクエスト
+// 目標はTargetかTargetTreeのどちらかで指定する
+type CalculateRequest struct {
+	Holdings   []Holding                 `json:"holdings"`
+	Target     yajirobe.AllocationTarget `json:"target,omitempty"`
+	TargetTree *yajirobe.TargetNode      `json:"target_tree,omitempty"`
+	Amount     float64                   `json:"amount"` // 積立額 0ならリバランス購入を計算しない
+}
+
+// fund 保有資産を検証してFundにする
+// 資産構成はParseCompositionと同じ規則で正規化する
+func (h *Holding) fund() (*yajirobe.Fund, error) {
+	if h.Code == "" {
+		return nil, errors.New("code is required")
+	}
+
+	if h.Amount < 0 || h.AcquisitionPrice < 0 || h.CurrentPrice < 0 {
+		return nil, errors.Errorf("%s: amount and prices must not be negative", h.Code)
+	}
+
+	f := &yajirobe.Fund{
+		Name:             h.Name,
+		Code:             yajirobe.FundCode(h.Code),
+		Amount:           h.Amount,
+		AssetClass:       h.Class,
+		AcquisitionPrice: h.AcquisitionPrice,
+		CurrentPrice:     h.CurrentPrice,
+	}
+
+	if h.Composition != nil {
+		comp, err := h.Composition.Normalize()
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s: invalid composition", h.Code)
+		}
+		f.Composition = comp
+	}
+
+	if h.Amount > 0 {
+		f.AcquisitionUnitPrice = h.AcquisitionPrice / float64(h.Amount) * 10000
+		f.CurrentUnitPrice = h.CurrentPrice / float64(h.Amount) * 10000
+	}
+
+	return f, nil
+}
+
+// Calculate アセットアロケーションとリバランス購入を計算する
+func (req *CalculateRequest) Calculate() (*yajirobe.Report, error) {
+	tree := req.TargetTree
+	switch {
+	case tree != nil && req.Target != nil:
+		return nil, errors.New("specify either target or target_tree, not both")
+	case tree == nil && req.Target == nil:
+		return nil, errors.New("target or target_tree is required")
+	case tree == nil:
+		tree = yajirobe.NewTargetTree(req.Target)
+	}
+
+	if err := tree.Validate(); err != nil {
+		return nil, err
+	}
+
+	if req.Amount < 0 {
+		return nil, errors.New("amount must not be negative")
+	}
+
+	funds := make([]*yajirobe.Fund, len(req.Holdings))
+	for i := range req.Holdings {
+		f, err := req.Holdings[i].fund()
+		if err != nil {
+			return nil, errors.Wrapf(err, "holding %d", i)
+		}
+		funds[i] = f
+	}
+
+	a := yajirobe.NewAssetAllocationTree([]*yajirobe.Stock{}, funds, tree)
+
+	report := a.Report()
+	if req.Amount > 0 {
+		report.SetBuy(a.RebalancingBuy(req.Amount))
+	}
+
+	return report, nil
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// calculateHandler 保有資産と目標を受け取ってアセットアロケーションとリバランス購入を返す
+// SBIにはログインしない
+type calculateHandler struct {
+	logger *zap.SugaredLogger
+}
+
+// NewCalculateHandler POSTされたCalculateRequestを計算してReportを返すhttp.Handlerを作る
+// If logger is nil, NewCalculateHandler uses NewNop as logger.
+func NewCalculateHandler(logger *zap.Logger) http.Handler {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return &calculateHandler{logger: logger.Sugar()}
+}
+
+func (h *calculateHandler) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := yajirobe.WriteJSON(w, v); err != nil {
+		h.logger.Errorf("web: %+v", err)
+	}
+}
+
+// ServeHTTP implements http.Handler
+func (h *calculateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		h.writeJSON(w, http.StatusMethodNotAllowed, errorResponse{"method not allowed"})
+		return
+	}
+
+	req := &CalculateRequest{}
+	d := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize))
+	d.DisallowUnknownFields()
+	if err := d.Decode(req); err != nil {
+		h.writeJSON(w, http.StatusBadRequest, errorResponse{errors.Wrap(err, "invalid request").Error()})
+		return
+	}
+
+	report, err := req.Calculate()
+	if err != nil {
+		h.writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,140 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func post(h http.Handler, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/api/calculate", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestCalculate(t *testing.T) {
+	h := NewCalculateHandler(nil)
+
+	w := post(h, `{
+		"holdings": [
+			{"code": "A", "class": "EmergingStocks", "acquisition_price": 100, "current_price": 200},
+			{"code": "B", "class": "DomesticStocks", "acquisition_price": 100, "current_price": 200},
+			{"code": "C", "class": "InternationalStocks", "acquisition_price": 300, "current_price": 600}
+		],
+		"target": {"EmergingStocks": 0.25, "DomesticStocks": 0.30, "InternationalStocks": 0.45},
+		"amount": 100
+	}`)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", w.Code, w.Body.String())
+	}
+
+	var report struct {
+		CurrentPrice float64 `json:"current_price"`
+		Buy          []struct {
+			Class  string  `json:"class"`
+			Amount float64 `json:"amount"`
+		} `json:"buy"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+
+	if report.CurrentPrice != 1000 {
+		t.Errorf("unexpected current price: %v", report.CurrentPrice)
+	}
+
+	buy := map[string]float64{}
+	for _, b := range report.Buy {
+		buy[b.Class] = b.Amount
+	}
+	if buy["DomesticStocks"] != 63 || buy["EmergingStocks"] != 37 {
+		t.Errorf("unexpected buy: %v", buy)
+	}
+}
+
+func TestCalculateTargetTree(t *testing.T) {
+	w := post(NewCalculateHandler(nil), `{
+		"holdings": [
+			{"code": "A", "class": "DomesticStocks", "current_price": 100},
+			{"code": "B", "class": "DomesticBonds", "current_price": 100}
+		],
+		"target_tree": {"ratio": 1, "children": [
+			{"name": "Stocks", "ratio": 0.5, "children": [{"class": "DomesticStocks", "ratio": 1}]},
+			{"class": "DomesticBonds", "ratio": 0.5}
+		]},
+		"amount": 100
+	}`)
+
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"amount": 50`) {
+		t.Errorf("unexpected response %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestCalculateComposition(t *testing.T) {
+	// 比率の合計が1でなくても正規化し、0の比率は取り除く
+	w := post(NewCalculateHandler(nil), `{
+		"holdings": [
+			{"code": "A", "class": "Balance", "composition": {"DomesticStocks": 3, "DomesticBonds": 1, "EmergingBonds": 0}, "current_price": 400}
+		],
+		"target": {"DomesticStocks": 0.5, "DomesticBonds": 0.5}
+	}`)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", w.Code, w.Body.String())
+	}
+
+	var report struct {
+		Classes []struct {
+			Class        string  `json:"class"`
+			CurrentPrice float64 `json:"current_price"`
+		} `json:"classes"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+
+	prices := map[string]float64{}
+	for _, c := range report.Classes {
+		prices[c.Class] = c.CurrentPrice
+	}
+	if len(prices) != 2 || prices["DomesticStocks"] != 300 || prices["DomesticBonds"] != 100 {
+		t.Errorf("unexpected classes: %v", prices)
+	}
+}
+
+func TestCalculateInvalid(t *testing.T) {
+	h := NewCalculateHandler(nil)
+
+	for _, body := range []string{
+		`{`,
+		`{"holdings": [], "unknown": 1, "target": {"DomesticStocks": 1}}`,
+		`{"holdings": []}`,
+		`{"holdings": [], "target": {"DomesticStocks": 0.5}}`,
+		`{"holdings": [], "target": {"Unknown": 1}}`,
+		`{"holdings": [{"class": "DomesticStocks"}], "target": {"DomesticStocks": 1}}`,
+		`{"holdings": [], "target": {"DomesticStocks": 1}, "amount": -1}`,
+		`{"holdings": [{"code": "A", "class": "Balance", "composition": {"DomesticStocks": -1, "DomesticBonds": 2}}], "target": {"DomesticStocks": 1}}`,
+		`{"holdings": [{"code": "A", "class": "Balance", "composition": {"DomesticStocks": 0}}], "target": {"DomesticStocks": 1}}`,
+		`{"holdings": [{"code": "A", "class": "Balance", "composition": {"Unknown": 1}}], "target": {"DomesticStocks": 1}}`,
+		`{"holdings": [{"code": "A", "class": 42}], "target": {"DomesticStocks": 1}}`,
+		`{"holdings": [{"code": "A", "class": "DomesticStocks", "current_price": -100}], "target": {"DomesticStocks": 1}}`,
+		`{"holdings": [{"code": "A", "class": "DomesticStocks", "acquisition_price": -100}], "target": {"DomesticStocks": 1}}`,
+		`{"holdings": [{"code": "A", "class": "DomesticStocks", "amount": -1}], "target": {"DomesticStocks": 1}}`,
+	} {
+		w := post(h, body)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"error"`) {
+			t.Errorf("%s: expected 400 but got %d %s", body, w.Code, w.Body.String())
+		}
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/calculate", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405 but got %d", w.Code)
+	}
+}
@@ -0,0 +1,83 @@
+package web
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// localGuard 手元で動かすサーバーを他のサイトから使われないようにする
+// DNSリバインディングで別の名前から読まれないようにHostを確かめ、
+// 他のサイトのフォームから送られたPOSTを拒否する
+type localGuard struct {
+	listenHost string
+	next       http.Handler
+}
+
+// NewLocalGuard listenで待ち受けるhへのリクエストのうち、他のサイトからのものを拒否するhttp.Handlerを作る
+// Hostはlocalhost、IPアドレス、listenのホスト名だけを受け付ける
+// GETとHEAD以外はOriginかSec-Fetch-Siteで同じオリジンからのリクエストであることを確かめる
+func NewLocalGuard(listen string, h http.Handler) http.Handler {
+	host, _, err := net.SplitHostPort(listen)
+	if err != nil {
+		host = listen
+	}
+	return &localGuard{listenHost: host, next: h}
+}
+
+// allowedHost Hostヘッダーの値を受け付けるか
+// リバインディングではIPアドレスでなく攻撃者のドメイン名が使われるので、IPアドレスは受け付ける
+func (g *localGuard) allowedHost(host string) bool {
+	name := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		name = h
+	}
+	name = strings.TrimSuffix(strings.Trim(name, "[]"), ".")
+
+	if name == "" {
+		return false
+	}
+
+	return strings.EqualFold(name, "localhost") ||
+		net.ParseIP(name) != nil ||
+		(g.listenHost != "" && strings.EqualFold(name, g.listenHost))
+}
+
+// sameOrigin リクエストが同じオリジンのページか、ブラウザ以外から送られたものか
+func sameOrigin(r *http.Request) bool {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "":
+	case "same-origin", "none":
+		return true
+	default:
+		return false
+	}
+
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		// ブラウザは他のサイトからのPOSTにOriginを付けるので、なければブラウザ以外から
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// ServeHTTP implements http.Handler
+func (g *localGuard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !g.allowedHost(r.Host) {
+		http.Error(w, "forbidden host", http.StatusForbidden)
+		return
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && !sameOrigin(r) {
+		http.Error(w, "cross-site request", http.StatusForbidden)
+		return
+	}
+
+	g.next.ServeHTTP(w, r)
+}
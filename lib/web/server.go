@@ -0,0 +1,332 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/masaedw/yajirobe/lib"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"golang.org/x/text/language"
+)
+
+// Loader 保有銘柄とファンドを読む
+// refreshならスキャンし直し、そうでなければ最後のスキャン結果を使ってよい
+// ctxはリクエストのcontextで、接続が切れたらスキャンをやめる
+type Loader func(ctx context.Context, refresh bool) (*yajirobe.Snapshot, error)
+
+// Server アセットアロケーションをブラウザで見るためのHTTPサーバー
+type Server struct {
+	load   Loader
+	target *yajirobe.TargetNode
+	lang   language.Tag
+	page   *template.Template
+	logger *zap.SugaredLogger
+	mux    *http.ServeMux
+	guard  http.Handler
+
+	mu       sync.Mutex
+	snapshot *yajirobe.Snapshot
+	loading  *loadCall
+}
+
+// loadCall 実行中のLoaderの呼び出し 同時に来たリクエストは終わるのを待って結果を使う
+type loadCall struct {
+	refresh  bool
+	done     chan struct{}
+	snapshot *yajirobe.Snapshot
+	err      error
+}
+
+// NewServer listenで待ち受け、langでページを書き出すServerを作る
+// 他のサイトからのリクエストはNewLocalGuardで拒否する
+// If logger is nil, NewServer uses NewNop as logger.
+func NewServer(load Loader, target *yajirobe.TargetNode, lang language.Tag, listen string, logger *zap.Logger) *Server {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	s := &Server{
+		load:   load,
+		target: target,
+		lang:   lang,
+		logger: logger.Sugar(),
+		mux:    http.NewServeMux(),
+	}
+	s.page = template.Must(pageTemplate.Clone()).Funcs(template.FuncMap{
+		"t": func(key string, args ...interface{}) string {
+			return yajirobe.Translate(lang, key, args...)
+		},
+	})
+
+	s.mux.HandleFunc("/", s.handleAllocation)
+	s.mux.HandleFunc("/funds", s.handleFunds)
+	s.mux.HandleFunc("/rebalance", s.handleRebalance)
+	s.mux.HandleFunc("/report", s.handleReport)
+	s.mux.HandleFunc("/refresh", s.handleRefresh)
+	s.mux.HandleFunc("/api/allocation", s.handleAPIAllocation)
+	s.mux.HandleFunc("/api/rebalance", s.handleAPIRebalance)
+	s.mux.Handle("/api/calculate", NewCalculateHandler(logger))
+	s.guard = NewLocalGuard(listen, s.mux)
+
+	return s
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.guard.ServeHTTP(w, r)
+}
+
+// allocation 保有資産を読んでアセットアロケーションを計算する
+// 一度読んだ保有資産はrefreshするまで使い回す
+func (s *Server) allocation(ctx context.Context, refresh bool) (*yajirobe.AssetAllocation, *yajirobe.Snapshot, error) {
+	snapshot, err := s.loadSnapshot(ctx, refresh)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "can't load holdings")
+	}
+
+	a := yajirobe.NewAssetAllocationTree(snapshot.Stocks, snapshot.Funds, s.target)
+	return &a, snapshot, nil
+}
+
+// loadSnapshot 保有資産を読む
+// ロックするのはキャッシュを読み書きする間だけで、スキャンしている間も読んだ保有資産を使える
+// 同時にスキャンが必要になったら、最初のリクエストのスキャンが終わるのを待って結果を使う
+func (s *Server) loadSnapshot(ctx context.Context, refresh bool) (*yajirobe.Snapshot, error) {
+	for {
+		s.mu.Lock()
+		if s.snapshot != nil && !refresh {
+			snapshot := s.snapshot
+			s.mu.Unlock()
+			return snapshot, nil
+		}
+
+		call := s.loading
+		if call == nil {
+			call = &loadCall{refresh: refresh, done: make(chan struct{})}
+			s.loading = call
+			s.mu.Unlock()
+
+			call.snapshot, call.err = s.load(ctx, refresh)
+
+			s.mu.Lock()
+			if call.err == nil {
+				s.snapshot = call.snapshot
+			}
+			s.loading = nil
+			s.mu.Unlock()
+			close(call.done)
+
+			return call.snapshot, call.err
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, errors.WithStack(ctx.Err())
+		}
+
+		// スキャンし直すリクエストは、最後のスキャン結果を使ったかもしれない読み込みの結果を使わない
+		if !refresh || call.refresh {
+			return call.snapshot, call.err
+		}
+	}
+}
+
+func (s *Server) error(w http.ResponseWriter, err error, status int) {
+	s.logger.Errorf("web: %+v", err)
+	http.Error(w, err.Error(), status)
+}
+
+// amount クエリのamountを積立額として読む
+func amount(r *http.Request) (float64, bool, error) {
+	v := r.URL.Query().Get("amount")
+	if v == "" {
+		return 0, false, nil
+	}
+
+	a, err := strconv.ParseFloat(v, 64)
+	if err != nil || a < 0 {
+		return 0, false, errors.Errorf("invalid amount: %s", v)
+	}
+
+	return a, true, nil
+}
+
+type page struct {
+	Lang      string
+	Path      string
+	ScannedAt string
+	Amount    string
+	Content   template.HTML
+}
+
+// renderPage rendererの出力をナビゲーションつきのページにして書き出す
+// クエリにamountがあればリバランス購入も書き出す
+func (s *Server) renderPage(w http.ResponseWriter, r *http.Request, renderer yajirobe.Renderer) {
+	v, ok, err := amount(r)
+	if err != nil {
+		s.error(w, err, http.StatusBadRequest)
+		return
+	}
+
+	a, snapshot, err := s.allocation(r.Context(), false)
+	if err != nil {
+		s.error(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	var buy map[yajirobe.AssetClass]float64
+	if ok {
+		buy = a.RebalancingBuy(v)
+	}
+
+	content := &bytes.Buffer{}
+	if err := renderer.Render(content, a, buy); err != nil {
+		s.error(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	data := page{
+		Lang:      yajirobe.HTMLLang(s.lang),
+		Path:      r.URL.Path,
+		ScannedAt: snapshot.ScannedAt.Format("2006-01-02 15:04"),
+		Amount:    r.URL.Query().Get("amount"),
+		Content:   template.HTML(content.String()),
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := s.page.Execute(w, data); err != nil {
+		s.logger.Errorf("web: %+v", err)
+	}
+}
+
+func (s *Server) handleAllocation(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	s.renderPage(w, r, &yajirobe.HTMLRenderer{RenderOption: yajirobe.RenderOption{Lang: s.lang}})
+}
+
+func (s *Server) handleFunds(w http.ResponseWriter, r *http.Request) {
+	s.renderPage(w, r, &yajirobe.HTMLRenderer{RenderOption: yajirobe.RenderOption{Funds: true, Lang: s.lang}})
+}
+
+func (s *Server) handleRebalance(w http.ResponseWriter, r *http.Request) {
+	s.renderPage(w, r, &yajirobe.HTMLRenderer{RenderOption: yajirobe.RenderOption{Lang: s.lang}})
+}
+
+func (s *Server) handleReport(w http.ResponseWriter, r *http.Request) {
+	a, _, err := s.allocation(r.Context(), false)
+	if err != nil {
+		s.error(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := yajirobe.WriteHTMLReport(w, a, s.lang); err != nil {
+		s.logger.Errorf("web: %+v", err)
+	}
+}
+
+// handleRefresh スキャンし直して元のページに戻る
+func (s *Server) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if _, _, err := s.allocation(r.Context(), true); err != nil {
+		s.error(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	back := r.FormValue("back")
+	if !strings.HasPrefix(back, "/") || strings.HasPrefix(back, "//") {
+		back = "/"
+	}
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := yajirobe.WriteJSON(w, v); err != nil {
+		s.logger.Errorf("web: %+v", err)
+	}
+}
+
+func (s *Server) handleAPIAllocation(w http.ResponseWriter, r *http.Request) {
+	a, _, err := s.allocation(r.Context(), false)
+	if err != nil {
+		s.error(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	s.writeJSON(w, a.Report())
+}
+
+func (s *Server) handleAPIRebalance(w http.ResponseWriter, r *http.Request) {
+	v, ok, err := amount(r)
+	if err != nil || !ok {
+		if err == nil {
+			err = errors.New("amount is required")
+		}
+		s.error(w, err, http.StatusBadRequest)
+		return
+	}
+
+	a, _, err := s.allocation(r.Context(), false)
+	if err != nil {
+		s.error(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	report := a.Report()
+	report.SetBuy(a.RebalancingBuy(v))
+	s.writeJSON(w, report)
+}
+
+// pageTemplate ページのテンプレート tはServerごとの言語で翻訳する関数に置き換える
+var pageTemplate = template.Must(template.New("page").Funcs(template.FuncMap{
+	"t": fmt.Sprintf,
+}).Parse(`<!DOCTYPE html>
+<html lang="{{.Lang}}">
+<head>
+<meta charset="utf-8">
+<title>yajirobe</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #333; }
+nav a { margin-right: 1em; }
+nav form { display: inline; }
+table { border-collapse: collapse; margin: 1em 0; }
+th, td { border: 1px solid #ccc; padding: 0.2em 0.6em; }
+td:first-child { white-space: pre; }
+.scanned { color: #777; font-size: 0.9em; }
+</style>
+</head>
+<body>
+<nav>
+<a href="/">{{t "Allocation"}}</a>
+<a href="/funds">{{t "Funds"}}</a>
+<a href="/rebalance">{{t "Rebalance"}}</a>
+<a href="/report">{{t "Report"}}</a>
+<form method="post" action="/refresh"><input type="hidden" name="back" value="{{.Path}}"><button>{{t "Refresh"}}</button></form>
+</nav>
+<p class="scanned">{{t "Scanned at %s" .ScannedAt}}</p>
+{{if eq .Path "/rebalance"}}<form method="get" action="/rebalance">
+<input type="number" name="amount" min="0" value="{{.Amount}}" required>
+<button>{{t "Calculate"}}</button>
+</form>
+{{end}}{{.Content}}
+</body>
+</html>
+`))
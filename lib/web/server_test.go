@@ -0,0 +1,323 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/masaedw/yajirobe/lib"
+	"golang.org/x/text/language"
+)
+
+func testFunds() []*yajirobe.Fund {
+	funds := []*yajirobe.Fund{}
+	for _, f := range []struct {
+		class yajirobe.AssetClass
+		price float64
+	}{
+		{yajirobe.EmergingStocks, 200},
+		{yajirobe.DomesticStocks, 200},
+		{yajirobe.InternationalStocks, 600},
+	} {
+		funds = append(funds, &yajirobe.Fund{
+			Name:             "TestFund",
+			Code:             yajirobe.FundCode(f.class.Key()),
+			AssetClass:       f.class,
+			AcquisitionPrice: f.price / 2,
+			CurrentPrice:     f.price,
+		})
+	}
+	return funds
+}
+
+func testServer(loads *[]bool) *Server {
+	target := yajirobe.NewTargetTree(yajirobe.AllocationTarget{
+		yajirobe.EmergingStocks:      0.25,
+		yajirobe.DomesticStocks:      0.30,
+		yajirobe.InternationalStocks: 0.45,
+	})
+
+	load := func(ctx context.Context, refresh bool) (*yajirobe.Snapshot, error) {
+		*loads = append(*loads, refresh)
+		return &yajirobe.Snapshot{ScannedAt: time.Now(), Funds: testFunds()}, nil
+	}
+
+	return NewServer(load, target, language.Und, testListen, nil)
+}
+
+// testListen テストのサーバーが待ち受けていることにするアドレス
+const testListen = "127.0.0.1:8080"
+
+// newRequest テストのサーバーに送るリクエストを作る
+func newRequest(method, url string, body io.Reader) *http.Request {
+	r := httptest.NewRequest(method, url, body)
+	r.Host = testListen
+	return r
+}
+
+func get(t *testing.T, h http.Handler, url string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, newRequest(http.MethodGet, url, nil))
+	return w
+}
+
+func TestPages(t *testing.T) {
+	loads := []bool{}
+	s := testServer(&loads)
+
+	for _, test := range []struct {
+		url      string
+		contains string
+	}{
+		{"/", "yajirobe-allocation"},
+		{"/funds", "yajirobe-funds"},
+		{"/rebalance?amount=100", "yajirobe-buy"},
+		{"/report", "<svg"},
+	} {
+		w := get(t, s, test.url)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: unexpected status %d", test.url, w.Code)
+		}
+		if !strings.Contains(w.Body.String(), test.contains) {
+			t.Errorf("%s: expected to contain %q but got\n%s", test.url, test.contains, w.Body.String())
+		}
+	}
+
+	// 保有資産は最初に一度だけ読む
+	if len(loads) != 1 || loads[0] {
+		t.Errorf("unexpected loads: %v", loads)
+	}
+
+	if w := get(t, s, "/unknown"); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 but got %d", w.Code)
+	}
+	for _, url := range []string{"/rebalance?amount=abc", "/?amount=abc", "/funds?amount=-1"} {
+		if w := get(t, s, url); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400 but got %d", url, w.Code)
+		}
+	}
+}
+
+func TestConcurrentLoad(t *testing.T) {
+	target := yajirobe.NewTargetTree(yajirobe.AllocationTarget{yajirobe.DomesticStocks: 1})
+
+	var mu sync.Mutex
+	loads := 0
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	load := func(ctx context.Context, refresh bool) (*yajirobe.Snapshot, error) {
+		mu.Lock()
+		loads++
+		mu.Unlock()
+		if refresh {
+			select {
+			case started <- struct{}{}:
+			default:
+			}
+			<-release
+		}
+		return &yajirobe.Snapshot{ScannedAt: time.Now(), Funds: testFunds()}, nil
+	}
+	s := NewServer(load, target, language.Und, testListen, nil)
+
+	if _, _, err := s.allocation(context.Background(), false); err != nil {
+		t.Fatal(err)
+	}
+
+	wg := sync.WaitGroup{}
+	refresh := func() {
+		defer wg.Done()
+		if _, _, err := s.allocation(context.Background(), true); err != nil {
+			t.Error(err)
+		}
+	}
+
+	wg.Add(1)
+	go refresh()
+	<-started
+
+	// スキャンしている間に来たリクエストはスキャンが終わるのを待つ
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go refresh()
+	}
+
+	// スキャンしている間も読んだ保有資産でページを返す
+	if w := get(t, s, "/"); w.Code != http.StatusOK {
+		t.Errorf("unexpected status %d", w.Code)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	// 同時にスキャンし直すリクエストは1回のスキャンの結果を使う
+	if loads != 2 {
+		t.Errorf("expected 2 loads but got %d", loads)
+	}
+}
+
+func TestLanguage(t *testing.T) {
+	load := func(ctx context.Context, refresh bool) (*yajirobe.Snapshot, error) {
+		return &yajirobe.Snapshot{ScannedAt: time.Now(), Funds: testFunds()}, nil
+	}
+	target := yajirobe.NewTargetTree(yajirobe.AllocationTarget{yajirobe.DomesticStocks: 1})
+
+	// サーバーごとの言語で書き出し、ほかのサーバーの言語に影響されない
+	ja := NewServer(load, target, language.Japanese, testListen, nil)
+	en := NewServer(load, target, language.English, testListen, nil)
+
+	for _, test := range []struct {
+		s        *Server
+		contains []string
+	}{
+		{ja, []string{`<html lang="ja">`, ">配分</a>", "<th>アセットクラス</th>", "<td>国内株式</td>"}},
+		{en, []string{`<html lang="en">`, ">Allocation</a>", "<th>Class</th>", "<td>Domestic Stocks</td>"}},
+	} {
+		body := get(t, test.s, "/").Body.String()
+		for _, c := range test.contains {
+			if !strings.Contains(body, c) {
+				t.Errorf("expected to contain %q but got\n%s", c, body)
+			}
+		}
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	loads := []bool{}
+	s := testServer(&loads)
+
+	if w := get(t, s, "/refresh"); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405 but got %d", w.Code)
+	}
+
+	w := httptest.NewRecorder()
+	r := newRequest(http.MethodPost, "/refresh", strings.NewReader("back=/funds"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	s.ServeHTTP(w, r)
+
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/funds" {
+		t.Errorf("unexpected response: %d %s", w.Code, w.Header().Get("Location"))
+	}
+	if len(loads) != 1 || !loads[0] {
+		t.Errorf("expected to scan again: %v", loads)
+	}
+}
+
+func TestAPI(t *testing.T) {
+	loads := []bool{}
+	s := testServer(&loads)
+
+	w := get(t, s, "/api/rebalance?amount=100")
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", w.Code, w.Body.String())
+	}
+
+	report := &yajirobe.Report{}
+	if err := json.Unmarshal(w.Body.Bytes(), report); err != nil {
+		t.Fatal(err)
+	}
+
+	if report.CurrentPrice != 1000 || len(report.Classes) != 3 || len(report.Buy) != 2 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+
+	if w := get(t, s, "/api/rebalance"); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 without amount but got %d", w.Code)
+	}
+
+	if w := get(t, s, "/api/allocation"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"class": "DomesticStocks"`) {
+		t.Errorf("unexpected allocation: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestForeignHost(t *testing.T) {
+	loads := []bool{}
+	s := testServer(&loads)
+
+	for _, host := range []string{"evil.example.com", "evil.example.com:8080", "localhost.evil.example.com", ""} {
+		w := httptest.NewRecorder()
+		r := newRequest(http.MethodGet, "/api/allocation", nil)
+		r.Host = host
+		s.ServeHTTP(w, r)
+
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%q: expected 403 but got %d", host, w.Code)
+		}
+	}
+
+	for _, host := range []string{"localhost:8080", "LOCALHOST", "127.0.0.1:8080", "[::1]:8080", "192.168.1.2:8080"} {
+		w := httptest.NewRecorder()
+		r := newRequest(http.MethodGet, "/api/allocation", nil)
+		r.Host = host
+		s.ServeHTTP(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("%q: expected 200 but got %d", host, w.Code)
+		}
+	}
+
+	// 他のサイトからは保有資産を読ませない
+	if len(loads) != 1 {
+		t.Errorf("unexpected loads: %v", loads)
+	}
+}
+
+func TestListenHost(t *testing.T) {
+	s := NewServer(nil, nil, language.Und, "yajirobe.home.arpa:8080", nil)
+
+	w := httptest.NewRecorder()
+	r := newRequest(http.MethodGet, "/unknown", nil)
+	r.Host = "yajirobe.home.arpa:8080"
+	s.ServeHTTP(w, r)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected the listen host to be allowed but got %d", w.Code)
+	}
+}
+
+func TestCrossSiteRefresh(t *testing.T) {
+	loads := []bool{}
+	s := testServer(&loads)
+
+	for _, header := range []map[string]string{
+		{"Origin": "https://evil.example.com"},
+		{"Origin": "null"},
+		{"Sec-Fetch-Site": "cross-site"},
+		{"Sec-Fetch-Site": "same-site", "Origin": "http://" + testListen},
+	} {
+		w := httptest.NewRecorder()
+		r := newRequest(http.MethodPost, "/refresh", strings.NewReader("back=/funds"))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for k, v := range header {
+			r.Header.Set(k, v)
+		}
+		s.ServeHTTP(w, r)
+
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%v: expected 403 but got %d", header, w.Code)
+		}
+	}
+
+	if len(loads) != 0 {
+		t.Errorf("expected not to scan: %v", loads)
+	}
+
+	// 同じオリジンのページからのPOSTは受け付ける
+	w := httptest.NewRecorder()
+	r := newRequest(http.MethodPost, "/refresh", strings.NewReader("back=/funds"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Origin", "http://"+testListen)
+	r.Header.Set("Sec-Fetch-Site", "same-origin")
+	s.ServeHTTP(w, r)
+
+	if w.Code != http.StatusSeeOther || len(loads) != 1 {
+		t.Errorf("expected to scan again but got %d %v", w.Code, loads)
+	}
+}
@@ -1,26 +1,95 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"time"
 
 	"github.com/masaedw/yajirobe/lib"
+	"github.com/masaedw/yajirobe/lib/storedmap"
+	"github.com/masaedw/yajirobe/lib/web"
+	"github.com/pkg/errors"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
-	"golang.org/x/text/message"
+	"golang.org/x/term"
+	"golang.org/x/text/language"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
 
 var (
-	app   = kingpin.New("yajirobe", "Asset allocation rebalance tool")
-	debug = app.Flag("debug", "Enable debug mode").Default("false").Bool()
+	app        = kingpin.New("yajirobe", "Asset allocation rebalance tool")
+	debug      = app.Flag("debug", "Enable debug mode").Default("false").Bool()
+	configPath = app.Flag("config", "Path to the config file").String()
+	dataDir    = app.Flag("data-dir", "Directory to keep the config, cache and scan results in. Defaults to $YAJIROBE_HOME or the XDG base directories").String()
+	format     = app.Flag("format", "Output format. Which formats are available depends on the command").Enum(yajirobe.RenderFormats...)
+	noColor    = app.Flag("no-color", "Disable colored output").Bool()
+	outputLang = app.Flag("lang", "Output language (ja, en, or auto to detect from LANG). English labels with Japanese class names if unset").String()
+	refresh    = app.Flag("refresh", "Fetch fund info from SBI even if it is cached").Bool()
+	workers    = app.Flag("fetch-workers", "How many fund pages to fetch concurrently before the scan (0 to fetch them one by one)").Default("4").Int()
+	interval   = app.Flag("fetch-interval", "Minimum interval between fund page requests (0 to disable)").Default("500ms").Duration()
+	timeout    = app.Flag("http-timeout", "Timeout of each HTTP request to SBI").Default("30s").Duration()
+	retries    = app.Flag("http-retries", "How many times to retry a failed GET request to SBI (0 to disable)").Default("3").Int()
+	throttle   = app.Flag("http-interval", "Minimum interval between any HTTP requests to SBI (0 to disable)").Default("200ms").Duration()
+	cacheTTL   = app.Flag("cache-ttl", "How long cached fund info stays valid (0 to never expire)").Default("720h").Duration()
+	encrypt    = app.Flag("encrypt", "Encrypt the cache with a passphrase. Once encrypted, the cache stays encrypted").Bool()
+	keyFile    = app.Flag("key-file", "Path to the passphrase-protected key file of the encrypted cache").String()
+	cacheStore = app.Flag("cache-store", "Where to store the cache: a single database file (bolt) or a file per entry (file)").Default("bolt").Enum("bolt", "file")
 
-	show = app.Command("show", "Show your asset allocation").Default()
+	show      = app.Command("show", "Show your asset allocation").Default()
+	showFunds = show.Flag("funds", "Show every fund and stock under its asset class").Bool()
+	showChart = show.Flag("chart", "Show a bar chart of actual vs target ratios").Bool()
+
+	report     = app.Command("report", "Write a report of your asset allocation")
+	reportHTML = report.Flag("html", "Path to the HTML file").Required().String()
 
 	buy       = app.Command("buy", "Calculate re-balancing buy")
 	buyAmount = buy.Arg("amount", "amount").Required().Int64()
 
+	serve        = app.Command("serve", "Serve your asset allocation as web pages and a JSON API")
+	serveListen  = serve.Flag("listen", "Address to listen on").Default("127.0.0.1:8080").String()
+	serveAPIOnly = serve.Flag("api-only", "Serve only the calculation API, which doesn't log in to SBI").Bool()
+
+	tui       = app.Command("tui", "Review and rebalance your asset allocation interactively")
+	tuiAmount = tui.Arg("amount", "initial contribution amount").Int64()
+
+	override                   = app.Command("override", "Manage asset class overrides")
+	overrideSet                = override.Command("set", "Override the asset class of a fund")
+	overrideSetCode            = overrideSet.Arg("code", "fund code").Required().String()
+	overrideSetClass           = overrideSet.Arg("class", "asset class (e.g. DomesticStocks or 国内株式)").Required().String()
+	overrideComposition        = override.Command("composition", "Set the composition of a balance fund")
+	overrideCompositionCode    = overrideComposition.Arg("code", "fund code").Required().String()
+	overrideCompositionWeights = overrideComposition.Arg("weights", "class=weight pairs (e.g. DomesticStocks=12.5)").Required().Strings()
+	overrideUnset              = override.Command("unset", "Remove the overrides of a fund")
+	overrideUnsetCode          = overrideUnset.Arg("code", "fund code").Required().String()
+	overrideList               = override.Command("list", "List your funds with scraped and effective asset classes")
+
+	cacheCmd                = app.Command("cache", "Manage cached fund info")
+	cacheList               = cacheCmd.Command("list", "List cached fund info")
+	cacheGet                = cacheCmd.Command("get", "Show cached fund info of a fund").Alias("show")
+	cacheGetCode            = cacheGet.Arg("code", "fund code").Required().String()
+	cacheSet                = cacheCmd.Command("set", "Edit cached fund info of a fund, or add it")
+	cacheSetCode            = cacheSet.Arg("code", "fund code").Required().String()
+	cacheSetName            = cacheSet.Flag("name", "fund name").String()
+	cacheSetClass           = cacheSet.Flag("class", "asset class (e.g. DomesticStocks or 国内株式)").String()
+	cacheSetCategory        = cacheSet.Flag("category", "SBI's fund category").String()
+	cacheSetComposition     = cacheSet.Flag("composition", "class=weight pair of a balance fund (repeatable)").Strings()
+	cacheDelete             = cacheCmd.Command("delete", "Remove cached fund info of funds").Alias("invalidate")
+	cacheDeleteCodes        = cacheDelete.Arg("codes", "fund codes").Required().Strings()
+	cachePurge              = cacheCmd.Command("purge", "Remove all cached fund info")
+	cachePurgeExpired       = cachePurge.Flag("expired", "Remove only expired fund info").Bool()
+	cacheExport             = cacheCmd.Command("export", "Write all cached fund info to a JSON file")
+	cacheExportPath         = cacheExport.Arg("file", "path to the JSON file (stdout if omitted)").String()
+	cacheImport             = cacheCmd.Command("import", "Read fund info from a JSON file written by export")
+	cacheImportPath         = cacheImport.Arg("file", "path to the JSON file").Required().String()
+	cacheImportKeepExisting = cacheImport.Flag("keep-existing", "Don't overwrite fund info already cached").Bool()
+	cacheRekey              = cacheCmd.Command("rekey", "Re-encrypt the cache with a new key and passphrase")
+
 	logger *zap.Logger
+	lang   language.Tag
 )
 
 func getAllocationTarget() yajirobe.AllocationTarget {
@@ -58,50 +127,733 @@ func createLogger() {
 	}
 }
 
-func main() {
+// setLanguage --langから出力に使う言語を決める
+// 指定されていなければlanguage.Undのままにして以前と同じ表示にする
+func setLanguage() {
+	switch *outputLang {
+	case "":
+		return
+	case "auto":
+		lang, _ = yajirobe.DetectLanguage()
+		return
+	}
+
+	tag, err := yajirobe.ParseLanguage(*outputLang)
+	if err != nil {
+		errorExit(err)
+	}
+	lang = tag
+}
+
+func loadConfig() (*yajirobe.Config, string) {
+	path := *configPath
+	if path == "" {
+		var err error
+		if path, err = yajirobe.DefaultConfigPath(); err != nil {
+			errorExit(err)
+		}
+	}
+
+	config, err := yajirobe.LoadConfig(path)
+	if err != nil {
+		errorExit(err)
+	}
+
+	return config, path
+}
+
+func saveConfig(config *yajirobe.Config, path string) {
+	if err := config.Save(path); err != nil {
+		errorExit(err)
+	}
+}
+
+// setDataDir データを置くディレクトリを決めて、以前の$HOME/.yajirobeから移す
+func setDataDir() {
+	storedmap.SetDataDir(*dataDir)
+
+	if err := storedmap.MigrateLegacyDir(logger); err != nil {
+		errorExit(err)
+	}
+}
+
+// checkFetchFlags 取得する数や間隔のフラグが負でないか調べる
+func checkFetchFlags() error {
+	if *workers < 0 {
+		return errors.New("--fetch-workers must not be negative")
+	}
+	if *interval < 0 {
+		return errors.New("--fetch-interval must not be negative")
+	}
+	if *retries < 0 {
+		return errors.New("--http-retries must not be negative")
+	}
+	if *throttle < 0 {
+		return errors.New("--http-interval must not be negative")
+	}
+	return nil
+}
+
+// httpOption フラグからHTTPリクエストの設定を作る
+// HTTPOptionは0を既定値として扱うので、フラグの0は負の値にして「しない」にする
+func httpOption() yajirobe.HTTPOption {
+	option := yajirobe.HTTPOption{Timeout: *timeout, Retries: *retries, Interval: *throttle}
+	if option.Retries == 0 {
+		option.Retries = -1
+	}
+	if option.Interval == 0 {
+		option.Interval = -1
+	}
+	return option
+}
+
+// scanSnapshot SBIをスキャンして結果を保存する
+// ctxが取り消されたらスキャンをやめる
+func scanSnapshot(ctx context.Context, config *yajirobe.Config, cache yajirobe.Cache) (*yajirobe.Snapshot, error) {
+	if err := checkFetchFlags(); err != nil {
+		return nil, err
+	}
+
 	userID := os.Getenv("SBI_USER_ID")
 	password := os.Getenv("SBI_USER_PASSWORD")
 
-	command := kingpin.MustParse(app.Parse(os.Args[1:]))
-	createLogger()
+	classifier, err := config.Classifier()
+	if err != nil {
+		return nil, err
+	}
+
+	// SbiOptionもHTTPOptionと同じくフラグの0を「しない」にする
+	fundInfoWorkers, fundInfoInterval := *workers, *interval
+	if fundInfoWorkers == 0 {
+		fundInfoWorkers = -1
+	}
+	if fundInfoInterval == 0 {
+		fundInfoInterval = -1
+	}
+
+	sbi, err := yajirobe.NewSbiScanner(ctx, yajirobe.SbiOption{
+		UserID:     userID,
+		Password:   password,
+		Logger:     logger,
+		Cache:      cache,
+		Classifier: classifier,
+		Refresh:    *refresh,
+
+		FundInfoWorkers:  fundInfoWorkers,
+		FundInfoInterval: fundInfoInterval,
 
-	cache, err := yajirobe.NewFileCache(logger)
+		HTTP: httpOption(),
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	s, f, err := sbi.Scan(ctx)
 	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &yajirobe.Snapshot{ScannedAt: time.Now(), Stocks: s, Funds: f}
+	if err := yajirobe.SaveSnapshot(cache, snapshot); err != nil {
+		logger.Warn("can't save snapshot", zap.Error(err))
+	}
+
+	return snapshot, nil
+}
+
+func scan(config *yajirobe.Config) ([]*yajirobe.Stock, []*yajirobe.Fund) {
+	// Ctrl-Cで送信中のリクエストを取り消してすぐに終わる
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	snapshot, err := scanSnapshot(ctx, config, openCache())
+	if err != nil {
+		errorExit(err)
+	}
+
+	return snapshot.Stocks, snapshot.Funds
+}
+
+// serveDashboard 保有資産を見るためのHTTPサーバーを起動する
+// 最初は最後のスキャン結果を使い、なければスキャンする
+func serveDashboard(config *yajirobe.Config, tree *yajirobe.TargetNode) {
+	cache := openCache()
+
+	load := func(ctx context.Context, refresh bool) (*yajirobe.Snapshot, error) {
+		snapshot, err := yajirobe.LoadSnapshot(cache)
+		if refresh || err != nil {
+			snapshot, err = scanSnapshot(ctx, config, cache)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		snapshot.Funds = config.Apply(snapshot.Stocks, snapshot.Funds)
+		return snapshot, nil
+	}
+
+	listen(web.NewServer(load, tree, lang, *serveListen, logger))
+}
+
+// serveCalculateAPI 保有資産と目標を受け取って計算するAPIだけを提供する
+func serveCalculateAPI() {
+	mux := http.NewServeMux()
+	mux.Handle("/api/calculate", web.NewCalculateHandler(logger))
+	listen(web.NewLocalGuard(*serveListen, mux))
+}
+
+func listen(handler http.Handler) {
+	logger.Sugar().Infof("listening on http://%s/", *serveListen)
+	if err := http.ListenAndServe(*serveListen, handler); err != nil {
 		errorExit(err)
 	}
+}
 
-	sbi, err := yajirobe.NewSbiScanner(yajirobe.SbiOption{
-		UserID:   userID,
-		Password: password,
-		Logger:   logger,
-		Cache:    cache,
+// openStoredMap 暗号化する前のキャッシュのStoredMapを開く
+func openStoredMap() storedmap.StoredMap {
+	newMap := storedmap.NewBoltMap
+	if *cacheStore == "file" {
+		newMap = storedmap.NewFileMap
+	}
+
+	smap, err := newMap(logger)
+	if err != nil {
+		errorExit(err)
+	}
+	return smap
+}
+
+func openCache() yajirobe.Cache {
+	return yajirobe.NewCache(encryptStoredMap(openStoredMap()), *cacheTTL)
+}
+
+func keyFilePath() string {
+	if *keyFile != "" {
+		return *keyFile
+	}
+
+	path, err := storedmap.DefaultKeyFilePath()
+	if err != nil {
+		errorExit(err)
+	}
+	return path
+}
+
+// readPassphrase 環境変数envか端末からパスフレーズを読む
+func readPassphrase(env, prompt string) []byte {
+	if p := os.Getenv(env); p != "" {
+		return []byte(p)
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		errorExit(errors.Errorf("the cache is encrypted: set %s or run in a terminal", env))
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	p, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		errorExit(err)
+	}
+	return p
+}
+
+// readNewPassphrase 新しいパスフレーズを読む 端末からなら確認のために2回入力する
+func readNewPassphrase(env string) []byte {
+	if p := os.Getenv(env); p != "" {
+		return []byte(p)
+	}
+
+	p := readPassphrase(env, "New passphrase: ")
+	if string(readPassphrase(env, "Confirm passphrase: ")) != string(p) {
+		errorExit(errors.New("passphrases don't match"))
+	}
+	if len(p) == 0 {
+		errorExit(errors.New("passphrase must not be empty"))
+	}
+	return p
+}
+
+// encryptStoredMap 鍵ファイルがあればsmapを暗号化するStoredMapで包む
+// --encryptで鍵ファイルがなければ鍵ファイルを作って今のエントリを暗号化する
+// 暗号化したキャッシュを鍵ファイルなしで開いて、平文のエントリを混ぜないようにする
+func encryptStoredMap(smap storedmap.StoredMap) storedmap.StoredMap {
+	path := keyFilePath()
+
+	k, err := storedmap.ReadKeyFile(path)
+	if os.IsNotExist(errors.Cause(err)) {
+		encrypted, err := storedmap.IsEncrypted(smap)
+		if err != nil {
+			errorExit(err)
+		}
+		if encrypted {
+			errorExit(errors.Errorf("the cache is encrypted but the key file %s doesn't exist: set --key-file", path))
+		}
+		if !*encrypt {
+			return smap
+		}
+		return enableEncryption(smap, path)
+	}
+	if err != nil {
+		errorExit(err)
+	}
+
+	key, err := k.Unlock(readPassphrase("YAJIROBE_PASSPHRASE", "Passphrase: "))
+	if err != nil {
+		errorExit(err)
+	}
+
+	encrypted, err := storedmap.NewEncryptedMap(smap, key)
+	if err != nil {
+		errorExit(err)
+	}
+	return encrypted
+}
+
+// enableEncryption 鍵ファイルを作ってsmapのエントリを暗号化する
+func enableEncryption(smap storedmap.StoredMap, path string) storedmap.StoredMap {
+	k, key, err := storedmap.NewKeyFile(readNewPassphrase("YAJIROBE_PASSPHRASE"))
+	if err != nil {
+		errorExit(err)
+	}
+
+	// 鍵をなくさないように先に鍵ファイルを書く
+	if err := k.Write(path); err != nil {
+		errorExit(err)
+	}
+
+	n, err := storedmap.Reencrypt(smap, nil, key)
+	if err != nil {
+		errorExit(err)
+	}
+	logger.Sugar().Infof("encrypted %d cache entries with %s", n, path)
+
+	// 暗号化する前の値をファイルに残さない
+	if err := storedmap.Compact(smap); err != nil {
+		errorExit(err)
+	}
+
+	encrypted, err := storedmap.NewEncryptedMap(smap, key)
+	if err != nil {
+		errorExit(err)
+	}
+	return encrypted
+}
+
+// rekeyCache 新しいデータ鍵とパスフレーズでキャッシュを暗号化し直す
+func rekeyCache() {
+	path := keyFilePath()
+	smap := openStoredMap()
+
+	k, err := storedmap.ReadKeyFile(path)
+	if err != nil {
+		errorExit(err)
+	}
+
+	oldKey, err := k.Unlock(readPassphrase("YAJIROBE_PASSPHRASE", "Current passphrase: "))
+	if err != nil {
+		errorExit(err)
+	}
+
+	newKeyFile, newKey, err := storedmap.NewKeyFile(readNewPassphrase("YAJIROBE_NEW_PASSPHRASE"))
+	if err != nil {
+		errorExit(err)
+	}
+
+	// 暗号化し直す前に新しい鍵ファイルを書いておき、暗号化し直してから置き換える
+	newPath := path + ".new"
+	if err := newKeyFile.Write(newPath); err != nil {
+		errorExit(err)
+	}
+
+	n, err := storedmap.Reencrypt(smap, oldKey, newKey)
+	if err != nil {
+		os.Remove(newPath)
+		errorExit(err)
+	}
+
+	if err := os.Rename(newPath, path); err != nil {
+		errorExit(errors.Wrapf(err, "the cache is encrypted with the key in %s", newPath))
+	}
+	logger.Sugar().Infof("re-encrypted %d cache entries", n)
+
+	// 古い鍵で暗号化した値をファイルに残さない
+	if err := storedmap.Compact(smap); err != nil {
+		errorExit(err)
+	}
+}
+
+func listCache(cache yajirobe.Cache) {
+	entries, err := yajirobe.NewCacheEntries(cache)
+	if err != nil {
+		errorExit(err)
+	}
+
+	if *format == "json" {
+		err = yajirobe.WriteJSON(os.Stdout, entries)
+	} else {
+		yajirobe.RenderCacheEntries(os.Stdout, entries, lang)
+	}
+	if err != nil {
+		errorExit(err)
+	}
+}
+
+func showCache(cache yajirobe.Cache, code yajirobe.FundCode) {
+	fi, err := cache.GetFund(code)
+	if err != nil {
+		errorExit(err)
+	}
+
+	if err := yajirobe.WriteJSON(os.Stdout, fi); err != nil {
+		errorExit(err)
+	}
+}
+
+// setCache キャッシュしているファンド情報の指定された項目を書き換える
+// キャッシュしていなければ新しく追加する
+func setCache(cache yajirobe.Cache, code yajirobe.FundCode) {
+	// 有効期限を過ぎていても書き換えない項目はそのまま残す
+	fi, err := cache.GetFund(code)
+	if storedmap.IsNotExists(err) {
+		fi, err = &yajirobe.FundInfo{Code: code}, nil
+	}
+	if err != nil {
+		errorExit(err)
+	}
+
+	if *cacheSetName != "" {
+		fi.Name = *cacheSetName
+	}
+	if *cacheSetClass != "" {
+		class, err := yajirobe.ParseAssetClassName(*cacheSetClass)
+		if err != nil {
+			errorExit(err)
+		}
+		fi.Class = class
+	}
+	if *cacheSetCategory != "" {
+		fi.Category = *cacheSetCategory
+	}
+	if len(*cacheSetComposition) > 0 {
+		comp, err := yajirobe.ParseComposition(*cacheSetComposition)
+		if err != nil {
+			errorExit(err)
+		}
+		fi.Composition = comp
+	}
+
+	// 書き換えた時刻から有効期限を数え直し、SBIから取得し直して上書きしないようにする
+	fi.UpdatedAt = time.Time{}
+	fi.Pinned = true
+	if err := cache.SetFund(fi); err != nil {
+		errorExit(err)
+	}
+}
+
+func exportCache(cache yajirobe.Cache, path string) {
+	if path == "" {
+		if err := yajirobe.ExportFunds(cache, os.Stdout); err != nil {
+			errorExit(err)
+		}
+		return
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		errorExit(err)
+	}
+
+	if err := yajirobe.ExportFunds(cache, f); err != nil {
+		f.Close()
+		errorExit(err)
+	}
+
+	if err := f.Close(); err != nil {
+		errorExit(err)
+	}
+}
+
+func importCache(cache yajirobe.Cache, path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		errorExit(err)
+	}
+	defer f.Close()
+
+	n, err := yajirobe.ImportFunds(cache, f, !*cacheImportKeepExisting)
+	if err != nil {
+		errorExit(err)
+	}
+	logger.Sugar().Infof("imported %d funds", n)
+}
+
+// purgeCache キャッシュしているファンド情報を消す
+// expiredなら有効期限を過ぎたものだけ消す
+func purgeCache(cache yajirobe.Cache, expired bool) {
+	codes, err := cache.FundCodes()
+	if err != nil {
+		errorExit(err)
+	}
+
+	purge := []yajirobe.FundCode{}
+	for _, code := range codes {
+		if !expired || cache.IsFundExpired(code) {
+			purge = append(purge, code)
+		}
+	}
+
+	if err := cache.DeleteFunds(purge); err != nil {
+		errorExit(err)
+	}
+}
+
+// checkFormat --formatがcommandで書き出せる形式か調べる
+// 指定されていなければsupportedの最初の形式にする
+func checkFormat(command string, supported ...string) {
+	if *format == "" {
+		if len(supported) > 0 {
+			*format = supported[0]
+		}
+		return
+	}
+
+	for _, f := range supported {
+		if f == *format {
+			return
+		}
+	}
+	errorExit(errors.Errorf("%s doesn't support --format %s", command, *format))
+}
+
+func render(a *yajirobe.AssetAllocation, buy map[yajirobe.AssetClass]float64) {
+	r, err := yajirobe.NewRenderer(*format, yajirobe.RenderOption{
+		Funds: *showFunds,
+		Lang:  lang,
 	})
+	if err != nil {
+		errorExit(err)
+	}
+
+	if err := r.Render(os.Stdout, a, buy); err != nil {
+		errorExit(err)
+	}
+}
+
+// terminalWidth 端末の幅 端末でなければ$COLUMNS、それもなければ80
+func terminalWidth() int {
+	if w, _, err := term.GetSize(int(os.Stdout.Fd())); err == nil && w > 0 {
+		return w
+	}
+	if w, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && w > 0 {
+		return w
+	}
+	return 80
+}
+
+// useColor 色つきで書き出すか
+// --no-colorかNO_COLORが指定されているか、端末でなければ色をつけない
+func useColor() bool {
+	if *noColor || os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	return term.IsTerminal(int(os.Stdout.Fd()))
+}
 
+func renderChart(a *yajirobe.AssetAllocation) {
+	r := &yajirobe.ChartRenderer{
+		RenderOption: yajirobe.RenderOption{Lang: lang},
+		Width:        terminalWidth(),
+		NoColor:      !useColor(),
+	}
+
+	if err := r.Render(os.Stdout, a, nil); err != nil {
+		errorExit(err)
+	}
+}
+
+func writeHTMLReport(a *yajirobe.AssetAllocation, path string) {
+	f, err := os.Create(path)
 	if err != nil {
 		errorExit(err)
 	}
 
-	s, f, err := sbi.Scan()
+	if err := yajirobe.WriteHTMLReport(f, a, lang); err != nil {
+		f.Close()
+		errorExit(err)
+	}
+
+	if err := f.Close(); err != nil {
+		errorExit(err)
+	}
+}
+
+func renderClassOverrides(funds []*yajirobe.Fund, overrides yajirobe.ClassOverrides) {
+	var err error
+	switch *format {
+	case "json":
+		err = yajirobe.WriteJSON(os.Stdout, yajirobe.NewFundClassReports(funds, overrides))
+	case "csv":
+		err = yajirobe.WriteFundClassReportsCSV(os.Stdout, yajirobe.NewFundClassReports(funds, overrides))
+	default:
+		yajirobe.RenderClassOverrides(os.Stdout, funds, overrides, lang)
+	}
 	if err != nil {
 		errorExit(err)
 	}
+}
+
+func main() {
+	command := kingpin.MustParse(app.Parse(os.Args[1:]))
+	createLogger()
+	setLanguage()
+	setDataDir()
 
-	a := yajirobe.NewAssetAllocation(s, f, getAllocationTarget())
+	config, path := loadConfig()
+
+	switch command {
+	case overrideSet.FullCommand():
+		class, err := yajirobe.ParseAssetClassName(*overrideSetClass)
+		if err != nil {
+			errorExit(err)
+		}
+		if config.Overrides == nil {
+			config.Overrides = yajirobe.ClassOverrides{}
+		}
+		config.Overrides[yajirobe.FundCode(*overrideSetCode)] = class
+		saveConfig(config, path)
+		return
+
+	case overrideComposition.FullCommand():
+		comp, err := yajirobe.ParseComposition(*overrideCompositionWeights)
+		if err != nil {
+			errorExit(err)
+		}
+		if config.Compositions == nil {
+			config.Compositions = map[yajirobe.FundCode]yajirobe.Composition{}
+		}
+		config.Compositions[yajirobe.FundCode(*overrideCompositionCode)] = comp
+		saveConfig(config, path)
+		return
+
+	case overrideUnset.FullCommand():
+		delete(config.Overrides, yajirobe.FundCode(*overrideUnsetCode))
+		delete(config.Compositions, yajirobe.FundCode(*overrideUnsetCode))
+		saveConfig(config, path)
+		return
+
+	case cacheList.FullCommand():
+		checkFormat(command, "table", "json")
+		listCache(openCache())
+		return
+
+	case cacheGet.FullCommand():
+		checkFormat(command, "json")
+		showCache(openCache(), yajirobe.FundCode(*cacheGetCode))
+		return
+
+	case cacheSet.FullCommand():
+		setCache(openCache(), yajirobe.FundCode(*cacheSetCode))
+		return
+
+	case cacheDelete.FullCommand():
+		codes := []yajirobe.FundCode{}
+		for _, code := range *cacheDeleteCodes {
+			codes = append(codes, yajirobe.FundCode(code))
+		}
+		if err := openCache().DeleteFunds(codes); err != nil {
+			errorExit(err)
+		}
+		return
+
+	case cachePurge.FullCommand():
+		purgeCache(openCache(), *cachePurgeExpired)
+		return
+
+	case cacheRekey.FullCommand():
+		rekeyCache()
+		return
+
+	case cacheExport.FullCommand():
+		checkFormat(command, "json")
+		exportCache(openCache(), *cacheExportPath)
+		return
+
+	case cacheImport.FullCommand():
+		importCache(openCache(), *cacheImportPath)
+		return
+
+	case serve.FullCommand():
+		checkFormat(command)
+		if *serveAPIOnly {
+			serveCalculateAPI()
+			return
+		}
+
+		tree, err := config.TargetTree(getAllocationTarget())
+		if err != nil {
+			errorExit(err)
+		}
+		serveDashboard(config, tree)
+		return
+	}
+
+	switch command {
+	case overrideList.FullCommand():
+		checkFormat(command, "table", "json", "csv")
+	case show.FullCommand():
+		if *showChart {
+			checkFormat("show --chart")
+		} else {
+			checkFormat(command, yajirobe.RenderFormats...)
+		}
+	case buy.FullCommand():
+		checkFormat(command, yajirobe.RenderFormats...)
+	case report.FullCommand(), tui.FullCommand():
+		checkFormat(command)
+	}
+
+	s, f := scan(config)
+
+	if command == overrideList.FullCommand() {
+		renderClassOverrides(f, config.Overrides)
+		return
+	}
+
+	f = config.Apply(s, f)
+
+	tree, err := config.TargetTree(getAllocationTarget())
+	if err != nil {
+		errorExit(err)
+	}
+
+	a := yajirobe.NewAssetAllocationTree(s, f, tree)
 
 	switch command {
 	case show.FullCommand():
-		a.Render()
+		if *showChart {
+			renderChart(&a)
+		} else {
+			render(&a, nil)
+		}
 
 	case buy.FullCommand():
 		result := a.RebalancingBuy(float64(*buyAmount))
-		a.Render()
+		render(&a, result)
 
-		p := message.NewPrinter(message.MatchLanguage("en"))
+	case report.FullCommand():
+		writeHTMLReport(&a, *reportHTML)
 
-		for _, c := range yajirobe.AssetClasses {
-			if v, e := result[c]; e {
-				p.Printf("%v\t%10.0f\n", c, v)
-			}
+	case tui.FullCommand():
+		d := yajirobe.NewDashboard(s, f, tree, *tuiAmount, func(t *yajirobe.TargetNode) error {
+			config.Target = t
+			return config.Save(path)
+		})
+		d.Lang = lang
+		if err := yajirobe.RunDashboard(os.Stdin, os.Stdout, d); err != nil {
+			errorExit(err)
 		}
 	}
 }